@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Operations recorded in the audit trail
+const (
+	auditCreate  = "create"
+	auditUpdate  = "update"
+	auditDelete  = "delete"
+	auditRestore = "restore"
+	auditPurge   = "purge"
+)
+
+// AuditEntry records a change of a person: who made it, when, and the
+// person before and after. Before is missing for a create or a restore,
+// After for a delete or a purge. Entries are never changed or removed.
+type AuditEntry struct {
+	Id       int       `json:"id"`
+	PersonId int       `json:"personId"`
+	Actor    string    `json:"actor"`
+	Time     time.Time `json:"timestamp"`
+	Op       string    `json:"op"`
+	Before   *Person   `json:"before,omitempty"`
+	After    *Person   `json:"after,omitempty"`
+	// Tenant owns the entry, like the person it is about
+	Tenant string `json:"-"`
+}
+
+// AuditQuery selects the audit entries of the tenant of the context in the
+// order they were recorded. A PersonId of 0 selects the entries of every
+// person, a zero Since those of all time and a Limit of 0 all entries.
+// AfterId continues a page, it selects the entries recorded after the one
+// with that id.
+type AuditQuery struct {
+	PersonId int
+	Since    time.Time
+	AfterId  int
+	Limit    int
+}
+
+// matches reports whether entry is selected by the filters of query
+func (query AuditQuery) matches(entry AuditEntry) bool {
+	if query.PersonId != 0 && entry.PersonId != query.PersonId {
+		return false
+	}
+	return entry.Id > query.AfterId && !entry.Time.Before(query.Since)
+}
+
+// anonymousActor is recorded for changes made without authentication
+const anonymousActor = "anonymous"
+
+// purgeActor is recorded for the people the background purge removes
+const purgeActor = "purge"
+
+// newAuditEntry returns the entry of a change made at the given time in the
+// request ctx belongs to. Stores pass the updatedAt the change gave the
+// person, so the history matches Last-Modified.
+func newAuditEntry(ctx context.Context, op string, at time.Time, before *Person, after *Person) AuditEntry {
+	entry := AuditEntry{
+		Actor:  anonymousActor,
+		Time:   at,
+		Op:     op,
+		Before: before,
+		After:  after,
+		Tenant: tenantFrom(ctx),
+	}
+	if principal, ok := principalFrom(ctx); ok {
+		entry.Actor = principal.Subject
+	}
+	if after != nil {
+		entry.PersonId = after.Id
+	} else if before != nil {
+		entry.PersonId = before.Id
+	}
+	return entry
+}
+
+// newPurgeEntry returns the entry of the purge removing p
+func newPurgeEntry(p Person) AuditEntry {
+	entry := newAuditEntry(withTenant(context.Background(), p.Tenant), auditPurge, storeTime(), &p, nil)
+	entry.Actor = purgeActor
+	return entry
+}
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// parseAuditPage reads the limit and after_id parameters shared by the
+// audit routes
+func parseAuditPage(r *http.Request) (AuditQuery, []FieldError) {
+	query := AuditQuery{Limit: defaultAuditLimit}
+	fieldErrors := []FieldError{}
+
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxAuditLimit {
+			fieldErrors = append(fieldErrors, FieldError{"limit", "must be an integer between 1 and " + strconv.Itoa(maxAuditLimit)})
+		}
+		query.Limit = n
+	}
+
+	if value := r.URL.Query().Get("after_id"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			fieldErrors = append(fieldErrors, FieldError{"after_id", "must be a non-negative integer"})
+		}
+		query.AfterId = n
+	}
+	return query, fieldErrors
+}
+
+// GetPersonHistory returns the changes of a person, oldest first. The
+// history of a deleted person is still available.
+func GetPersonHistory(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	pId := params["id"]
+
+	id, err := strconv.Atoi(pId)
+	if err != nil {
+		writeInvalidId(w, r, pId)
+		return
+	}
+
+	query, fieldErrors := parseAuditPage(r)
+	if len(fieldErrors) > 0 {
+		writeValidationErrors(w, r, http.StatusBadRequest, fieldErrors)
+		return
+	}
+	query.PersonId = id
+
+	entries, err := store.getAudit(r.Context(), query)
+	if err != nil {
+		writeStoreError(w, r, err, "Could not load history of person "+pId)
+		return
+	}
+	if len(entries) == 0 && query.AfterId == 0 {
+		writeError(w, r, http.StatusNotFound, "Person "+pId+" has no history")
+		return
+	}
+	if len(entries) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	writeAuditEntries(w, r, entries, query.Limit)
+}
+
+// GetAudit returns the changes of all people since the RFC 3339 timestamp
+// in the since parameter, oldest first. A full page links to the next one,
+// which continues after the id of its last entry, so entries sharing a
+// timestamp are neither repeated nor skipped.
+func GetAudit(w http.ResponseWriter, r *http.Request) {
+	query, fieldErrors := parseAuditPage(r)
+
+	if value := r.URL.Query().Get("since"); value != "" {
+		parsed, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			fieldErrors = append(fieldErrors, FieldError{"since", "must be an RFC 3339 timestamp like 2024-01-31T08:00:00Z"})
+		}
+		query.Since = parsed.UTC()
+	}
+
+	if len(fieldErrors) > 0 {
+		writeValidationErrors(w, r, http.StatusBadRequest, fieldErrors)
+		return
+	}
+
+	entries, err := store.getAudit(r.Context(), query)
+	if err != nil {
+		writeStoreError(w, r, err, "Could not load audit trail")
+		return
+	}
+	if len(entries) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	writeAuditEntries(w, r, entries, query.Limit)
+}
+
+// writeAuditEntries writes a page of entries, linking to the next page if
+// the page is full
+func writeAuditEntries(w http.ResponseWriter, r *http.Request, entries []AuditEntry, limit int) {
+	entryBytes, err := json.Marshal(entries)
+
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Could not encode audit entries")
+		return
+	}
+
+	if len(entries) == limit {
+		values := r.URL.Query()
+		values.Set("after_id", strconv.Itoa(entries[len(entries)-1].Id))
+		values.Set("limit", strconv.Itoa(limit))
+		w.Header().Add("Link", "<"+r.URL.Path+"?"+values.Encode()+">; rel=\"next\"")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(entryBytes)
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+)
+
+// testAuditTrail checks that s records who created, changed and deleted a
+// person, and returns the entries by person, time and tenant
+func testAuditTrail(t *testing.T, s Store) {
+	start := time.Now().Add(-time.Second)
+	alice := context.WithValue(withTenant(context.Background(), "team-a"), principalKey{}, Principal{Subject:"alice"})
+	bob := context.WithValue(withTenant(context.Background(), "team-a"), principalKey{}, Principal{Subject:"bob"})
+
+	peter, err := s.createPerson(alice, Person{Name:"Peter", PhoneNr:"24525345626"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	peter.PhoneNr = "0791234567"
+	if _, err := s.updatePerson(bob, peter); err != nil {
+		t.Fatal(err)
+	}
+	paul, err := s.createPerson(alice, Person{Name:"Paul", PhoneNr:"643265776357948984"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.deletePerson(bob, peter.Id, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.createPerson(withTenant(context.Background(), "team-b"), Person{Name:"Alice", PhoneNr:"12343463462345243"}); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := s.getAudit(alice, AuditQuery{PersonId:peter.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 3 {
+		t.Fatalf("getAudit returned wrong number of entries: got %+v", history)
+	}
+	for i, expected := range []struct {
+		op, actor, before, after string
+	}{
+		{auditCreate, "alice", "", "24525345626"},
+		{auditUpdate, "bob", "24525345626", "0791234567"},
+		{auditDelete, "bob", "0791234567", ""},
+	} {
+		entry := history[i]
+		if entry.Op != expected.op || entry.Actor != expected.actor || entry.PersonId != peter.Id {
+			t.Errorf("entry %d has wrong operation: got %v by %v on %v want %v by %v on %v", i, entry.Op, entry.Actor, entry.PersonId, expected.op, expected.actor, peter.Id)
+		}
+		if (entry.Before == nil) != (expected.before == "") || (entry.Before != nil && entry.Before.PhoneNr != expected.before) {
+			t.Errorf("entry %d has wrong before: got %+v want phone number %q", i, entry.Before, expected.before)
+		}
+		if (entry.After == nil) != (expected.after == "") || (entry.After != nil && entry.After.PhoneNr != expected.after) {
+			t.Errorf("entry %d has wrong after: got %+v want phone number %q", i, entry.After, expected.after)
+		}
+		if entry.Time.Before(start) || entry.Time.After(time.Now()) {
+			t.Errorf("entry %d has wrong time: %v", i, entry.Time)
+		}
+	}
+
+	all, err := s.getAudit(alice, AuditQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 4 || all[2].PersonId != paul.Id {
+		t.Errorf("getAudit returned wrong entries of the tenant: %+v", all)
+	}
+	for i := 1; i < len(all); i++ {
+		if all[i].Id <= all[i-1].Id || all[i].Time.Before(all[i-1].Time) {
+			t.Errorf("getAudit returned entries out of order: %+v", all)
+		}
+	}
+
+	limited, err := s.getAudit(alice, AuditQuery{Since:all[1].Time, Limit:2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(limited) != 2 || limited[0].Time.Before(all[1].Time) {
+		t.Errorf("getAudit returned wrong entries since %v: %+v", all[1].Time, limited)
+	}
+	future, err := s.getAudit(alice, AuditQuery{Since:time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(future) != 0 {
+		t.Errorf("getAudit returned entries of the future: %+v", future)
+	}
+}
+
+// testAuditPaging checks that s pages through entries that share a
+// timestamp by id, record adds an entry to the trail of s
+func testAuditPaging(t *testing.T, s Store, record func(AuditEntry)) {
+	ctx := withTenant(context.Background(), "team-a")
+	at := time.Date(2024, 1, 31, 8, 0, 0, 0, time.UTC)
+	for personId := 1; personId <= 5; personId++ {
+		record(AuditEntry{PersonId:personId, Actor:purgeActor, Time:at, Op:auditPurge, Before:&Person{Id:personId, Name:"Peter", PhoneNr:"24525345626"}, Tenant:"team-a"})
+	}
+
+	seen := []int{}
+	query := AuditQuery{Since:at, Limit:2}
+	for page := 0; page < 5; page++ {
+		entries, err := s.getAudit(ctx, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) == 0 {
+			break
+		}
+		for _, entry := range entries {
+			seen = append(seen, entry.PersonId)
+		}
+		query.AfterId = entries[len(entries)-1].Id
+	}
+
+	expected := "[1 2 3 4 5]"
+	if fmt.Sprint(seen) != expected {
+		t.Errorf("paging through the audit trail returned wrong entries: got %v want %v", seen, expected)
+	}
+}
+
+func TestMemoryStoreRecordsAuditTrail(t *testing.T) {
+	testAuditTrail(t, NewMemoryStore())
+}
+
+func TestMemoryStorePagesAuditTrail(t *testing.T) {
+	memoryStore := NewMemoryStore()
+	testAuditPaging(t, memoryStore, memoryStore.addAudit)
+}
+
+func TestDbStorePagesAuditTrail(t *testing.T) {
+	sqliteStore := newSqliteTestStore(t)
+	testAuditPaging(t, sqliteStore, func(entry AuditEntry) {
+		if err := sqliteStore.audit(sqliteStore.db, entry); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestDbStoreRecordsAuditTrail(t *testing.T) {
+	sqliteStore := newSqliteTestStore(t)
+	testAuditTrail(t, sqliteStore)
+
+	if err := sqliteStore.db.Exec("UPDATE audit_log SET actor = 'mallory'").Error; err == nil {
+		t.Error("audit entries could be changed")
+	}
+	if err := sqliteStore.db.Exec("DELETE FROM audit_log").Error; err == nil {
+		t.Error("audit entries could be deleted")
+	}
+}
+
+func TestFileStoreRecordsAuditTrail(t *testing.T) {
+	dir := t.TempDir()
+	fileStore, err := OpenFileStore(FileStoreOptions{Dir:dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testAuditTrail(t, fileStore)
+	expected, err := fileStore.getAudit(withTenant(context.Background(), "team-a"), AuditQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reopenFileStore(t, fileStore, dir, func(fileStore *FileStore) {
+		recovered, err := fileStore.getAudit(withTenant(context.Background(), "team-a"), AuditQuery{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(recovered) != len(expected) || recovered[3].Id != expected[3].Id || !recovered[3].Time.Equal(expected[3].Time) || recovered[3].Actor != "bob" {
+			t.Errorf("getAudit returned wrong entries after reopening: got %+v want %+v", recovered, expected)
+		}
+	})
+}
+
+func TestGetPersonHistoryReturnsEntries(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	store = NewMockStore(mockCtrl)
+	mockStore := store.(*MockStore)
+
+	created := time.Date(2024, 1, 31, 8, 0, 0, 0, time.UTC)
+	entries := []AuditEntry{
+		{Id:1, PersonId:3, Actor:"alice", Time:created, Op:auditCreate, After:&Person{Id:3, Name:"Peter", PhoneNr:"24525345626"}},
+		{Id:4, PersonId:3, Actor:"bob", Time:created.Add(time.Hour), Op:auditUpdate, Before:&Person{Id:3, Name:"Peter", PhoneNr:"24525345626"}, After:&Person{Id:3, Name:"Peter", PhoneNr:"0791234567"}},
+	}
+	mockStore.EXPECT().getAudit(gomock.Any(), AuditQuery{PersonId:3, Limit:100}).Return(entries, nil).Times(1)
+
+	req, err := http.NewRequest("GET", "/people/3/history", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	router := createRouter()
+
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusOK
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+
+	expectedBody := `[{"id":1,"personId":3,"actor":"alice","timestamp":"2024-01-31T08:00:00Z","op":"create","after":{"id":3,"name":"Peter","phoneNr":"24525345626"}},` +
+		`{"id":4,"personId":3,"actor":"bob","timestamp":"2024-01-31T09:00:00Z","op":"update","before":{"id":3,"name":"Peter","phoneNr":"24525345626"},"after":{"id":3,"name":"Peter","phoneNr":"0791234567"}}]`
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+}
+
+func TestGetPersonHistoryReturnsNotFound(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	store = NewMockStore(mockCtrl)
+	mockStore := store.(*MockStore)
+
+	mockStore.EXPECT().getAudit(gomock.Any(), AuditQuery{PersonId:3, Limit:100}).Return([]AuditEntry{}, nil).Times(1)
+
+	req, err := http.NewRequest("GET", "/people/3/history", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	router := createRouter()
+
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusNotFound
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+
+	expectedBody := `{"type":"about:blank","title":"Not Found","status":404,"detail":"Person 3 has no history","instance":"/people/3/history","requestId":"test-request-id"}`
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+}
+
+func TestGetAuditReturnsEntriesSince(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	store = NewMockStore(mockCtrl)
+	mockStore := store.(*MockStore)
+
+	since := time.Date(2024, 1, 31, 8, 0, 0, 0, time.UTC)
+	entries := []AuditEntry{
+		{Id:7, PersonId:5, Actor:"alice", Time:since.Add(time.Minute), Op:auditDelete, Before:&Person{Id:5, Name:"Paul", PhoneNr:"643265776357948984"}},
+	}
+	mockStore.EXPECT().getAudit(gomock.Any(), AuditQuery{Since:since, Limit:10}).Return(entries, nil).Times(1)
+
+	req, err := http.NewRequest("GET", "/audit?since=2024-01-31T09:00:00%2B01:00&limit=10", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	router := createRouter()
+
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusOK
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+
+	expectedBody := `[{"id":7,"personId":5,"actor":"alice","timestamp":"2024-01-31T08:01:00Z","op":"delete","before":{"id":5,"name":"Paul","phoneNr":"643265776357948984"}}]`
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+}
+
+func TestGetAuditLinksNextPage(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	store = NewMockStore(mockCtrl)
+	mockStore := store.(*MockStore)
+
+	// Both pages share one timestamp, the next page continues by id
+	at := time.Date(2024, 1, 31, 8, 0, 0, 0, time.UTC)
+	gomock.InOrder(
+		mockStore.EXPECT().getAudit(gomock.Any(), AuditQuery{Since:at, Limit:2}).Return([]AuditEntry{
+			{Id:7, PersonId:5, Actor:purgeActor, Time:at, Op:auditPurge},
+			{Id:8, PersonId:6, Actor:purgeActor, Time:at, Op:auditPurge},
+		}, nil).Times(1),
+		mockStore.EXPECT().getAudit(gomock.Any(), AuditQuery{Since:at, AfterId:8, Limit:2}).Return([]AuditEntry{
+			{Id:9, PersonId:7, Actor:purgeActor, Time:at, Op:auditPurge},
+		}, nil).Times(1),
+	)
+
+	router := createRouter()
+	for _, page := range []struct {
+		url, expectedLink, expectedBody string
+	}{
+		{
+			"/audit?since=2024-01-31T08:00:00Z&limit=2",
+			`</audit?after_id=8&limit=2&since=2024-01-31T08%3A00%3A00Z>; rel="next"`,
+			`[{"id":7,"personId":5,"actor":"purge","timestamp":"2024-01-31T08:00:00Z","op":"purge"},{"id":8,"personId":6,"actor":"purge","timestamp":"2024-01-31T08:00:00Z","op":"purge"}]`,
+		},
+		{
+			"/audit?after_id=8&limit=2&since=2024-01-31T08%3A00%3A00Z",
+			"",
+			`[{"id":9,"personId":7,"actor":"purge","timestamp":"2024-01-31T08:00:00Z","op":"purge"}]`,
+		},
+	} {
+		req, err := http.NewRequest("GET", page.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		expectedStatus := http.StatusOK
+		if status := rr.Code; status != expectedStatus {
+			t.Errorf("handler returned wrong status code: got %v want %v",
+				status, expectedStatus)
+		}
+		if link := rr.Header().Get("Link"); link != page.expectedLink {
+			t.Errorf("handler returned wrong Link header: got %v want %v", link, page.expectedLink)
+		}
+		if rr.Body.String() != page.expectedBody {
+			t.Errorf("handler returned unexpected body: got %v want %v",
+				rr.Body.String(), page.expectedBody)
+		}
+	}
+}
+
+func TestGetAuditReturnsBadRequest(t *testing.T) {
+	req, err := http.NewRequest("GET", "/audit?since=yesterday&limit=0&after_id=-1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	router := createRouter()
+
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusBadRequest
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+
+	expectedBody := `{"type":"/problems/validation-error","title":"Validation failed","status":400,"detail":"One or more fields are invalid","instance":"/audit","errors":[{"field":"limit","message":"must be an integer between 1 and 1000"},{"field":"after_id","message":"must be a non-negative integer"},{"field":"since","message":"must be an RFC 3339 timestamp like 2024-01-31T08:00:00Z"}],"requestId":"test-request-id"}`
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+}
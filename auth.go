@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/gorilla/mux"
+)
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	Subject string
+	Roles   []string
+	Scopes  []string
+	// Tenant is the tenant the caller belongs to. Callers without tenant act
+	// for the default tenant, or choose one with the X-Tenant-ID header if
+	// they have the tenants:any scope.
+	Tenant string
+	// Method is how the caller authenticated, "api_key" or "jwt"
+	Method string
+}
+
+type principalKey struct{}
+
+// principalFrom returns the caller of the request ctx belongs to, false if
+// authentication is disabled or the route is public
+func principalFrom(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(Principal)
+	return principal, ok
+}
+
+// errNoCredentials is returned by an Authenticator when the request carries
+// no credentials it understands, so the next one can be tried
+var errNoCredentials = errors.New("no credentials")
+
+// Authenticator identifies the caller of a request
+type Authenticator interface {
+	authenticate(r *http.Request) (Principal, error)
+}
+
+// authenticators tries each authenticator until one finds credentials
+type authenticators []Authenticator
+
+func (list authenticators) authenticate(r *http.Request) (Principal, error) {
+	for _, authenticator := range list {
+		principal, err := authenticator.authenticate(r)
+		if err != errNoCredentials {
+			return principal, err
+		}
+	}
+	return Principal{}, errNoCredentials
+}
+
+// authenticator checks the callers of all routes but the public ones,
+// nil disables authentication. main sets it up with setupAuthentication.
+var authenticator Authenticator
+
+// publicRoutes are the names of the routes probes and scrapers call
+var publicRoutes = map[string]bool{"healthz": true, "readyz": true, "metrics": true}
+
+// setupAuthentication reads API keys from API_KEYS_FILE and JSON web keys
+// from JWKS_FILE. Without either the service refuses to start, unless
+// AUTH_DISABLED is true.
+func setupAuthentication() (Authenticator, error) {
+	list := authenticators{}
+
+	if path := getEnv("API_KEYS_FILE", ""); path != "" {
+		keys, err := loadAPIKeys(path)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, keys)
+	}
+
+	if path := getEnv("JWKS_FILE", ""); path != "" {
+		tokens, err := loadJWKS(path)
+		if err != nil {
+			return nil, err
+		}
+		tokens.issuer = getEnv("JWT_ISSUER", "")
+		tokens.audience = getEnv("JWT_AUDIENCE", "")
+		list = append(list, tokens)
+	}
+
+	if len(list) == 0 {
+		if getEnv("AUTH_DISABLED", "false") == "true" {
+			logger.Warn("authentication is disabled, every caller has full access")
+			return nil, nil
+		}
+		return nil, errors.New("set API_KEYS_FILE or JWKS_FILE, or AUTH_DISABLED=true to run without authentication")
+	}
+	return list, nil
+}
+
+// requireAuthentication rejects requests to non-public routes whose caller
+// cannot be authenticated and passes the principal on in the context
+func requireAuthentication(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authenticator == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if route := mux.CurrentRoute(r); route != nil && publicRoutes[route.GetName()] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		principal, err := authenticator.authenticate(r)
+		if err != nil {
+			writeUnauthorized(w, r, err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, principal)))
+	})
+}
+
+func writeUnauthorized(w http.ResponseWriter, r *http.Request, err error) {
+	loggerFrom(r.Context()).Info("authentication failed", "error", err.Error())
+
+	detail := "Authentication required, send a bearer token or an X-API-Key header"
+	if err != errNoCredentials {
+		detail = "Invalid credentials: " + err.Error()
+	}
+	w.Header().Set("WWW-Authenticate", `Bearer realm="people"`)
+	writeError(w, r, http.StatusUnauthorized, detail)
+}
+
+const apiKeyHeader = "X-API-Key"
+
+// apiKey is an entry of the API keys file
+type apiKey struct {
+	Key     string   `json:"key"`
+	Subject string   `json:"subject"`
+	Roles   []string `json:"roles"`
+	Scopes  []string `json:"scopes"`
+	Tenant  string   `json:"tenant"`
+}
+
+// apiKeyAuthenticator accepts the static keys of a file, usually a mounted
+// secret. Keys are kept as SHA-256 hashes, so looking one up does not
+// reveal how much of a guessed key was right.
+type apiKeyAuthenticator struct {
+	principals map[string]Principal
+}
+
+// loadAPIKeys reads a JSON array of keys with their subject, roles, scopes
+// and tenant
+func loadAPIKeys(path string) (*apiKeyAuthenticator, error) {
+	keysBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []apiKey{}
+	err = json.Unmarshal(keysBytes, &keys)
+	if err != nil {
+		return nil, fmt.Errorf("reading API keys: %v", err)
+	}
+
+	authenticator := &apiKeyAuthenticator{principals: map[string]Principal{}}
+	for i, key := range keys {
+		if key.Key == "" || key.Subject == "" {
+			return nil, fmt.Errorf("API key %d needs a key and a subject", i+1)
+		}
+		if key.Tenant != "" && !validTenant(key.Tenant) {
+			return nil, fmt.Errorf("API key %d has an invalid tenant %q", i+1, key.Tenant)
+		}
+		authenticator.principals[hashAPIKey(key.Key)] = Principal{Subject: key.Subject, Roles: key.Roles, Scopes: key.Scopes, Tenant: key.Tenant, Method: "api_key"}
+	}
+	return authenticator, nil
+}
+
+func (keys *apiKeyAuthenticator) authenticate(r *http.Request) (Principal, error) {
+	key := r.Header.Get(apiKeyHeader)
+	if key == "" {
+		return Principal{}, errNoCredentials
+	}
+	principal, ok := keys.principals[hashAPIKey(key)]
+	if !ok {
+		return Principal{}, errors.New("unknown API key")
+	}
+	return principal, nil
+}
+
+func hashAPIKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/golang/mock/gomock"
+)
+
+var testSecret = []byte("0123456789abcdef0123456789abcdef")
+
+// useAuthenticator enables authentication until the test finishes
+func useAuthenticator(t *testing.T, a Authenticator) {
+	t.Cleanup(func() { authenticator = nil })
+	authenticator = a
+}
+
+func writeTestFile(t *testing.T, name string, content interface{}) string {
+	contentBytes, err := json.Marshal(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), name)
+	err = os.WriteFile(path, contentBytes, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// testJWKS writes a JWKS with an HMAC key "hmac" and an RSA key "rsa"
+func testJWKS(t *testing.T) (*jwtAuthenticator, *rsa.PrivateKey) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := writeTestFile(t, "jwks.json", map[string]interface{}{"keys": []jsonWebKey{
+		{Kty: "oct", Kid: "hmac", Alg: "HS256", K: base64.RawURLEncoding.EncodeToString(testSecret)},
+		{Kty: "RSA", Kid: "rsa", Alg: "RS256",
+			N: base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()),
+			E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes())},
+	}})
+	tokens, err := loadJWKS(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokens, rsaKey
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func validClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub":   "alice",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"roles": []string{"editor"},
+		"scope": "people:read people:write",
+	}
+}
+
+func TestApiKeyAuthenticatesCaller(t *testing.T) {
+	path := writeTestFile(t, "keys.json", []apiKey{
+		{Key: "secret-key", Subject: "billing", Roles: []string{"reader"}, Scopes: []string{"people:read"}},
+	})
+	keys, err := loadAPIKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/people", nil)
+	req.Header.Set(apiKeyHeader, "secret-key")
+	principal, err := keys.authenticate(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Principal{Subject: "billing", Roles: []string{"reader"}, Scopes: []string{"people:read"}, Method: "api_key"}
+	if !reflect.DeepEqual(principal, expected) {
+		t.Errorf("wrong principal: got %+v want %+v", principal, expected)
+	}
+
+	req.Header.Set(apiKeyHeader, "secret-kez")
+	if _, err := keys.authenticate(req); err == nil || err == errNoCredentials {
+		t.Errorf("unknown key was not rejected: got %v", err)
+	}
+
+	req.Header.Del(apiKeyHeader)
+	if _, err := keys.authenticate(req); err != errNoCredentials {
+		t.Errorf("missing key was not reported: got %v want %v", err, errNoCredentials)
+	}
+}
+
+func TestLoadAPIKeysRejectsIncompleteKeys(t *testing.T) {
+	path := writeTestFile(t, "keys.json", []apiKey{{Key: "secret-key"}})
+	if _, err := loadAPIKeys(path); err == nil {
+		t.Errorf("key without subject was accepted")
+	}
+}
+
+func TestJwtAuthenticatesCaller(t *testing.T) {
+	tokens, rsaKey := testJWKS(t)
+
+	for _, tc := range []struct {
+		name  string
+		token string
+	}{
+		{"HS256", signToken(t, jwt.SigningMethodHS256, "hmac", testSecret, validClaims())},
+		{"RS256", signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, validClaims())},
+		{"RS256 without kid", signToken(t, jwt.SigningMethodRS256, "", rsaKey, validClaims())},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/people", nil)
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+			principal, err := tokens.authenticate(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := Principal{Subject: "alice", Roles: []string{"editor"}, Scopes: []string{"people:read", "people:write"}, Method: "jwt"}
+			if !reflect.DeepEqual(principal, expected) {
+				t.Errorf("wrong principal: got %+v want %+v", principal, expected)
+			}
+		})
+	}
+}
+
+func TestJwtRejectsInvalidTokens(t *testing.T) {
+	tokens, rsaKey := testJWKS(t)
+	tokens.issuer = "https://issuer.example"
+
+	withIssuer := func(claims jwt.MapClaims) jwt.MapClaims {
+		claims["iss"] = "https://issuer.example"
+		return claims
+	}
+	expired := withIssuer(validClaims())
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	noExpiry := withIssuer(validClaims())
+	delete(noExpiry, "exp")
+	noSubject := withIssuer(validClaims())
+	delete(noSubject, "sub")
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKeyBytes := rsaKey.PublicKey.N.Bytes()
+
+	for _, tc := range []struct {
+		name  string
+		token string
+	}{
+		{"expired", signToken(t, jwt.SigningMethodHS256, "hmac", testSecret, expired)},
+		{"without expiry", signToken(t, jwt.SigningMethodHS256, "hmac", testSecret, noExpiry)},
+		{"without subject", signToken(t, jwt.SigningMethodHS256, "hmac", testSecret, noSubject)},
+		{"wrong issuer", signToken(t, jwt.SigningMethodHS256, "hmac", testSecret, validClaims())},
+		{"unknown kid", signToken(t, jwt.SigningMethodHS256, "other", testSecret, withIssuer(validClaims()))},
+		{"wrong signature", signToken(t, jwt.SigningMethodRS256, "rsa", otherKey, withIssuer(validClaims()))},
+		{"HS256 with RSA key", signToken(t, jwt.SigningMethodHS256, "rsa", publicKeyBytes, withIssuer(validClaims()))},
+		{"HS512", signToken(t, jwt.SigningMethodHS512, "hmac", testSecret, withIssuer(validClaims()))},
+		{"none", signToken(t, jwt.SigningMethodNone, "", jwt.UnsafeAllowNoneSignatureType, withIssuer(validClaims()))},
+		{"malformed", "not-a-token"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/people", nil)
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+			if _, err := tokens.authenticate(req); err == nil || err == errNoCredentials {
+				t.Errorf("token was not rejected: got %v", err)
+			}
+		})
+	}
+}
+
+func TestLoadJWKSRejectsMismatchedAlgorithm(t *testing.T) {
+	path := writeTestFile(t, "jwks.json", map[string]interface{}{"keys": []jsonWebKey{
+		{Kty: "oct", Kid: "hmac", Alg: "RS256", K: base64.RawURLEncoding.EncodeToString(testSecret)},
+	}})
+	if _, err := loadJWKS(path); err == nil {
+		t.Errorf("oct key for RS256 was accepted")
+	}
+}
+
+func TestRouterReturnsUnauthorizedWithoutCredentials(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	store = NewMockStore(mockCtrl)
+
+	tokens, _ := testJWKS(t)
+	useAuthenticator(t, authenticators{tokens})
+
+	req, err := http.NewRequest("GET", "/people/3", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	router := createRouter()
+
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusUnauthorized
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+
+	expectedChallenge := `Bearer realm="people"`
+	if challenge := rr.Header().Get("WWW-Authenticate"); challenge != expectedChallenge {
+		t.Errorf("handler returned wrong challenge: got %v want %v",
+			challenge, expectedChallenge)
+	}
+
+	expectedBody := `{"type":"about:blank","title":"Unauthorized","status":401,"detail":"Authentication required, send a bearer token or an X-API-Key header","instance":"/people/3","requestId":"test-request-id"}`
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+}
+
+func TestRouterReturnsUnauthorizedForInvalidToken(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	store = NewMockStore(mockCtrl)
+
+	tokens, _ := testJWKS(t)
+	useAuthenticator(t, authenticators{tokens})
+
+	claims := validClaims()
+	claims["exp"] = time.Now().Add(-time.Hour).Unix()
+
+	req, err := http.NewRequest("GET", "/people/3", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+signToken(t, jwt.SigningMethodHS256, "hmac", testSecret, claims))
+
+	rr := httptest.NewRecorder()
+	router := createRouter()
+
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusUnauthorized
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+
+	expectedBody := `{"type":"about:blank","title":"Unauthorized","status":401,"detail":"Invalid credentials: token has invalid claims: token is expired","instance":"/people/3","requestId":"test-request-id"}`
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+}
+
+func TestRouterPassesPrincipalToHandlers(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	store = NewMockStore(mockCtrl)
+	mockStore := store.(*MockStore)
+
+	path := writeTestFile(t, "keys.json", []apiKey{{Key: "secret-key", Subject: "billing", Roles: []string{"reader"}}})
+	keys, err := loadAPIKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useAuthenticator(t, authenticators{keys})
+
+	var principal Principal
+	mockStore.EXPECT().getPerson(gomock.Any(), 3).DoAndReturn(func(ctx context.Context, id int) (Person, error) {
+		principal, _ = principalFrom(ctx)
+		return Person{Id:3, Name:"Peter", PhoneNr:"24525345626"}, nil
+	}).Times(1)
+
+	req, err := http.NewRequest("GET", "/people/3", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(apiKeyHeader, "secret-key")
+
+	rr := httptest.NewRecorder()
+	router := createRouter()
+
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusOK
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+	if principal.Subject != "billing" || principal.Method != "api_key" {
+		t.Errorf("handler got wrong principal: got %+v", principal)
+	}
+}
+
+func TestRouterKeepsProbesPublic(t *testing.T) {
+	tokens, _ := testJWKS(t)
+	useAuthenticator(t, authenticators{tokens})
+
+	req, err := http.NewRequest("GET", "/healthz", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	router := createRouter()
+
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusOK
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+}
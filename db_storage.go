@@ -2,42 +2,636 @@ package main
 
 import (
 	"github.com/jinzhu/gorm"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
 )
 
 type dbStore struct {
 	db *gorm.DB
 }
 
-func (store *dbStore) getPeople() ([]Person, error) {
+// contextDB runs the statements gorm sends with ctx, so they are canceled
+// together with the request
+type contextDB struct {
+	db  *sql.DB
+	ctx context.Context
+}
+
+func (db contextDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.db.ExecContext(db.ctx, query, args...)
+}
+
+func (db contextDB) Prepare(query string) (*sql.Stmt, error) {
+	return db.db.PrepareContext(db.ctx, query)
+}
+
+func (db contextDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.db.QueryContext(db.ctx, query, args...)
+}
+
+func (db contextDB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return db.db.QueryRowContext(db.ctx, query, args...)
+}
+
+func (db contextDB) Begin() (*sql.Tx, error) {
+	return db.db.BeginTx(db.ctx, nil)
+}
+
+// BeginTx ignores ctx, gorm's Begin passes context.Background
+func (db contextDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	return db.db.BeginTx(db.ctx, opts)
+}
+
+// with returns the database bound to ctx. gorm v1 has no context support,
+// so a handle on the same connection pool is opened for every call.
+func (store *dbStore) with(ctx context.Context) *gorm.DB {
+	db, _ := gorm.Open(store.db.Dialect().GetName(), contextDB{store.db.DB(), ctx})
+	return db
+}
+
+// tenant returns the database bound to ctx, limited to the people of the
+// tenant of ctx
+func (store *dbStore) tenant(ctx context.Context) *gorm.DB {
+	return store.with(ctx).Where("tenant = ?", tenantFrom(ctx))
+}
+
+func (store *dbStore) getPeople(ctx context.Context, query PeopleQuery) ([]Person, int, error) {
 	person := []Person{}
-	err := store.db.Find(&person).Error
-	return person, err
+	total := 0
+	db := filterPeople(store.tenant(ctx), query)
+	err := db.Model(&Person{}).Count(&total).Error
+	if err != nil {
+		return person, 0, dbError(ctx, err)
+	}
+
+	order := query.order()
+	if query.After != nil {
+		clause, args := keysetClause(order, *query.After, false)
+		db = db.Where(clause, args...)
+	}
+	if query.Before != nil {
+		// Walk backwards from the cursor and restore the order afterwards
+		clause, args := keysetClause(order, *query.Before, true)
+		db = db.Where(clause, args...)
+		for i := range order {
+			order[i].Descending = !order[i].Descending
+		}
+	}
+	for _, sortField := range order {
+		direction := " asc"
+		if sortField.Descending {
+			direction = " desc"
+		}
+		db = db.Order(sortColumns[sortField.Field] + direction)
+	}
+	if query.Offset > 0 {
+		db = db.Offset(query.Offset)
+	}
+	if query.Limit > 0 {
+		db = db.Limit(query.Limit)
+	}
+
+	err = db.Find(&person).Error
+	if query.Before != nil {
+		for i, j := 0, len(person)-1; i < j; i, j = i+1, j-1 {
+			person[i], person[j] = person[j], person[i]
+		}
+	}
+	return person, total, dbError(ctx, err)
+}
+
+// filterPeople adds the filters of query to db
+func filterPeople(db *gorm.DB, query PeopleQuery) *gorm.DB {
+	if query.IncludeDeleted {
+		db = db.Unscoped()
+	}
+	if query.Name != "" {
+		db = db.Where("name = ?", query.Name)
+	}
+	if query.NamePrefix != "" {
+		db = db.Where("name LIKE ? ESCAPE '\\'", escapeLike(query.NamePrefix)+"%")
+	}
+	if query.PhoneNrPrefix != "" {
+		db = db.Where("phone_nr LIKE ? ESCAPE '\\'", escapeLike(query.PhoneNrPrefix)+"%")
+	}
+	if !query.UpdatedSince.IsZero() {
+		db = db.Where("updated_at >= ?", query.UpdatedSince.UTC())
+	}
+	return db
+}
+
+func escapeLike(value string) string {
+	return strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_").Replace(value)
+}
+
+// keysetClause returns the where clause selecting the people sorted after
+// key in order, or before key if before is set
+func keysetClause(order []SortField, key Person, before bool) (string, []interface{}) {
+	values := map[string]interface{}{"id": key.Id, "name": key.Name, "phoneNr": key.PhoneNr}
+
+	alternatives := []string{}
+	args := []interface{}{}
+	for i, sortField := range order {
+		conditions := []string{}
+		for _, equal := range order[:i] {
+			conditions = append(conditions, sortColumns[equal.Field]+" = ?")
+			args = append(args, values[equal.Field])
+		}
+		operator := " > ?"
+		if sortField.Descending != before {
+			operator = " < ?"
+		}
+		conditions = append(conditions, sortColumns[sortField.Field]+operator)
+		args = append(args, values[sortField.Field])
+		alternatives = append(alternatives, "("+strings.Join(conditions, " AND ")+")")
+	}
+	return strings.Join(alternatives, " OR "), args
 }
 
-func (store *dbStore) getPerson(id int) (Person, error) {
+// AfterFind is called by gorm for every person it loads. The timestamps
+// are returned in UTC like those of the other stores, whatever the time
+// zone of the database session.
+func (p *Person) AfterFind() error {
+	p.CreatedAt = p.CreatedAt.UTC()
+	p.UpdatedAt = p.UpdatedAt.UTC()
+	if p.DeletedAt != nil {
+		deletedAt := p.DeletedAt.UTC()
+		p.DeletedAt = &deletedAt
+	}
+	return nil
+}
+
+func (store *dbStore) getPerson(ctx context.Context, id int) (Person, error) {
 	person := Person{}
-	notFound := store.db.First(&person, id).RecordNotFound()
-	if notFound {
-		return person, errors.New("Person not found")
+	err := store.tenant(ctx).First(&person, id).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return person, ErrNotFound
+	}
+	return person, dbError(ctx, err)
+}
+
+func (store *dbStore) createPerson(ctx context.Context, p Person) (Person, error) {
+	if err := checkPerson(p); err != nil {
+		return Person{}, err
+	}
+	p.Version = 1
+	p.Tenant = tenantFrom(ctx)
+	p.CreatedAt = storeTime()
+	p.UpdatedAt = p.CreatedAt
+	p.DeletedAt = nil
+
+	err := store.transaction(ctx, func(tx *gorm.DB) error {
+		err := store.checkQuota(tx, p.Tenant)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Create(&p).Error
+		if err != nil {
+			return err
+		}
+		return store.audit(tx, newAuditEntry(ctx, auditCreate, p.CreatedAt, nil, &p))
+	})
+	if err != nil {
+		return Person{}, err
+	}
+	return p, nil
+}
+
+// tenantLockKey is the first half of the advisory lock taken while
+// checking the quota of a tenant, the hash of the tenant is the second
+const tenantLockKey = 7340522
+
+// checkQuota returns ErrQuotaExceeded if tenant may not have another person
+// in tx. Concurrent checks for the same tenant wait for its lock, SQLite
+// only has one connection anyway.
+func (store *dbStore) checkQuota(tx *gorm.DB, tenant string) error {
+	if quotas.limit(tenant) == 0 {
+		return nil
+	}
+	if isPostgres(store.db) {
+		err := tx.Exec("SELECT pg_advisory_xact_lock(?, hashtext(?))", tenantLockKey, tenant).Error
+		if err != nil {
+			return err
+		}
+	}
+	count := 0
+	err := tx.Model(&Person{}).Where("tenant = ?", tenant).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	return quotas.exceeded(tenant, count)
+}
+
+func (store *dbStore) updatePerson(ctx context.Context, p Person) (Person, error) {
+	if err := checkPerson(p); err != nil {
+		return Person{}, err
+	}
+
+	updated := Person{}
+	err := store.transaction(ctx, func(tx *gorm.DB) error {
+		old, err := store.lockPerson(ctx, tx, p.Id, p.Version)
+		if err != nil {
+			return err
+		}
+
+		updated = old
+		updated.Name = p.Name
+		updated.PhoneNr = p.PhoneNr
+		updated.Version = old.Version + 1
+		updated.UpdatedAt = storeTime()
+		// Save would insert a new row for an unknown id, so update
+		// explicitly. Updates would set updated_at to the time of gorm.
+		err = tx.Model(&Person{}).Where("id = ?", p.Id).UpdateColumns(map[string]interface{}{
+			"name":       updated.Name,
+			"phone_nr":   updated.PhoneNr,
+			"version":    updated.Version,
+			"updated_at": updated.UpdatedAt,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return store.audit(tx, newAuditEntry(ctx, auditUpdate, updated.UpdatedAt, &old, &updated))
+	})
+	if err != nil {
+		return Person{}, err
+	}
+	return updated, nil
+}
+
+func (store *dbStore) deletePerson(ctx context.Context, id int, version int) error {
+	return store.transaction(ctx, func(tx *gorm.DB) error {
+		old, err := store.lockPerson(ctx, tx, id, version)
+		if err != nil {
+			return err
+		}
+
+		deletedAt := storeTime()
+		err = tx.Model(&Person{}).Where("id = ?", id).UpdateColumns(map[string]interface{}{
+			"deleted_at": deletedAt,
+			"updated_at": deletedAt,
+			"version":    old.Version + 1,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return store.audit(tx, newAuditEntry(ctx, auditDelete, deletedAt, &old, nil))
+	})
+}
+
+func (store *dbStore) restorePerson(ctx context.Context, id int, version int) (Person, error) {
+	restored := Person{}
+	err := store.transaction(ctx, func(tx *gorm.DB) error {
+		old, err := store.lockPerson(ctx, tx.Unscoped(), id, version)
+		if err != nil {
+			return err
+		}
+		if old.DeletedAt == nil {
+			return ErrNotDeleted
+		}
+		err = store.checkQuota(tx, old.Tenant)
+		if err != nil {
+			return err
+		}
+
+		restored = old
+		restored.DeletedAt = nil
+		restored.UpdatedAt = storeTime()
+		restored.Version = old.Version + 1
+		err = tx.Unscoped().Model(&Person{}).Where("id = ?", id).UpdateColumns(map[string]interface{}{
+			"deleted_at": nil,
+			"updated_at": restored.UpdatedAt,
+			"version":    restored.Version,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return store.audit(tx, newAuditEntry(ctx, auditRestore, restored.UpdatedAt, nil, &restored))
+	})
+	if err != nil {
+		return Person{}, err
+	}
+	return restored, nil
+}
+
+func (store *dbStore) purgeDeleted(ctx context.Context, before time.Time) (int, error) {
+	purged := 0
+	err := store.transaction(ctx, func(tx *gorm.DB) error {
+		expired := []Person{}
+		err := tx.Unscoped().Where("deleted_at < ?", before.UTC()).Order("id").Find(&expired).Error
+		if err != nil {
+			return err
+		}
+
+		for _, p := range expired {
+			// Another replica may have purged or restored the person since
+			deleted := tx.Unscoped().Where("id = ? AND deleted_at < ?", p.Id, before.UTC()).Delete(&Person{})
+			if deleted.Error != nil {
+				return deleted.Error
+			}
+			if deleted.RowsAffected == 0 {
+				continue
+			}
+			err = store.audit(tx, newPurgeEntry(p))
+			if err != nil {
+				return err
+			}
+			purged++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return purged, nil
+}
+
+// lockPerson loads the person with the given id of the tenant of ctx for a
+// change in tx and checks its version, a version of 0 matches any. Deleted
+// people are only found if tx is unscoped.
+func (store *dbStore) lockPerson(ctx context.Context, tx *gorm.DB, id int, version int) (Person, error) {
+	db := tx.Where("tenant = ?", tenantFrom(ctx))
+	if isPostgres(store.db) {
+		db = db.Set("gorm:query_option", "FOR UPDATE")
+	}
+
+	person := Person{}
+	err := db.First(&person, id).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return person, ErrNotFound
+	}
+	if err != nil {
+		return person, err
+	}
+	if version != 0 && version != person.Version {
+		return person, ErrVersionMismatch
 	}
 	return person, nil
 }
 
-func (store *dbStore) createPerson(p Person) error {
-	return store.db.Create(&p).Error
+// transaction runs change in a transaction bound to ctx and commits it if
+// change returns no error
+func (store *dbStore) transaction(ctx context.Context, change func(tx *gorm.DB) error) error {
+	tx := store.with(ctx).Begin()
+	if tx.Error != nil {
+		return dbError(ctx, tx.Error)
+	}
+	defer tx.Rollback()
+
+	err := change(tx)
+	if err == nil {
+		err = tx.Commit().Error
+	}
+	return dbError(ctx, err)
+}
+
+// ping checks that the database answers and all migrations are applied
+func (store *dbStore) ping(ctx context.Context) error {
+	err := store.db.DB().PingContext(ctx)
+	if err != nil {
+		return dbError(ctx, err)
+	}
+
+	db := store.with(ctx)
+	if !db.HasTable("schema_migrations") {
+		return fmt.Errorf("%w: the schema is not migrated", ErrUnavailable)
+	}
+	states, err := migrationStates(db)
+	if err != nil {
+		return dbError(ctx, err)
+	}
+	for _, state := range states {
+		if !state.Applied {
+			return fmt.Errorf("%w: migration %d %s is pending", ErrUnavailable, state.Version, state.Name)
+		}
+	}
+	return nil
+}
+
+// Close closes the connections to the database
+func (store *dbStore) Close() error {
+	return store.db.Close()
+}
+
+// Conditions and score of a search, matching scorePerson. @q stands for the
+// search text and @digits for its digits.
+const (
+	searchNameMatch  = "(name % @q OR to_tsvector('simple', name) @@ plainto_tsquery('simple', @q))"
+	searchPhoneMatch = "(@digits <> '' AND regexp_replace(phone_nr, '\\D', '', 'g') LIKE '%' || @digits || '%')"
+	searchScore      = "GREATEST(" +
+		"CASE WHEN " + searchNameMatch + " THEN GREATEST(similarity(name, @q), word_similarity(@q, name)) ELSE 0 END, " +
+		"CASE WHEN " + searchPhoneMatch + " THEN 0.5 + 0.5 * length(@digits) / length(regexp_replace(phone_nr, '\\D', '', 'g')) ELSE 0 END)"
+)
+
+var searchPlaceholder = regexp.MustCompile("@tenant|@q|@digits|@limit")
+
+func (store *dbStore) searchPeople(ctx context.Context, q string, limit int) ([]SearchResult, error) {
+	if !isPostgres(store.db) {
+		return store.scanPeople(ctx, q, limit)
+	}
+
+	results := []SearchResult{}
+	args := []interface{}{}
+	statement := "SELECT people.*, " + searchScore + " AS score FROM people" +
+		" WHERE tenant = @tenant AND deleted_at IS NULL AND (" + searchNameMatch + " OR " + searchPhoneMatch + ")" +
+		" ORDER BY score DESC, id LIMIT @limit"
+
+	// Replace the placeholders in order of appearance
+	statement = searchPlaceholder.ReplaceAllStringFunc(statement, func(name string) string {
+		switch name {
+		case "@tenant":
+			args = append(args, tenantFrom(ctx))
+			return "?"
+		case "@q":
+			args = append(args, q)
+			return "?::text"
+		case "@digits":
+			args = append(args, digits(q))
+			return "?::text"
+		}
+		args = append(args, limit)
+		return "?"
+	})
+
+	err := store.with(ctx).Raw(statement, args...).Scan(&results).Error
+	return results, dbError(ctx, err)
+}
+
+// scanPeople scores every person in the process, for databases without
+// trigram support
+func (store *dbStore) scanPeople(ctx context.Context, q string, limit int) ([]SearchResult, error) {
+	people := []Person{}
+	err := store.tenant(ctx).Find(&people).Error
+	if err != nil {
+		return nil, dbError(ctx, err)
+	}
+
+	results := []SearchResult{}
+	for _, person := range people {
+		if score := scorePerson(q, person); score > 0 {
+			results = append(results, SearchResult{person, score})
+		}
+	}
+	return sortResults(results, limit), nil
+}
+
+// auditRow is an audit entry as stored in the audit_log table, the people
+// before and after the change are kept as JSON
+type auditRow struct {
+	Id       int
+	Tenant   string
+	PersonId int
+	Actor    string
+	Op       string
+	At       time.Time
+	Before   *string
+	After    *string
+}
+
+func (auditRow) TableName() string {
+	return "audit_log"
+}
+
+// audit inserts entry in tx, so it is only recorded with the change
+func (store *dbStore) audit(tx *gorm.DB, entry AuditEntry) error {
+	row := auditRow{Tenant: entry.Tenant, PersonId: entry.PersonId, Actor: entry.Actor, Op: entry.Op, At: entry.Time}
+	for _, value := range []struct {
+		person *Person
+		column **string
+	}{{entry.Before, &row.Before}, {entry.After, &row.After}} {
+		if value.person == nil {
+			continue
+		}
+		personBytes, err := json.Marshal(value.person)
+		if err != nil {
+			return err
+		}
+		personJson := string(personBytes)
+		*value.column = &personJson
+	}
+	return tx.Create(&row).Error
 }
 
-func (store *dbStore) updatePerson(p Person) error {
-	return store.db.Save(&p).Error
+func (store *dbStore) getAudit(ctx context.Context, query AuditQuery) ([]AuditEntry, error) {
+	db := store.with(ctx).Where("tenant = ?", tenantFrom(ctx))
+	if query.PersonId != 0 {
+		db = db.Where("person_id = ?", query.PersonId)
+	}
+	if !query.Since.IsZero() {
+		db = db.Where("at >= ?", query.Since.UTC())
+	}
+	if query.AfterId > 0 {
+		db = db.Where("id > ?", query.AfterId)
+	}
+	if query.Limit > 0 {
+		db = db.Limit(query.Limit)
+	}
+
+	rows := []auditRow{}
+	err := db.Order("id").Find(&rows).Error
+	if err != nil {
+		return nil, dbError(ctx, err)
+	}
+
+	entries := []AuditEntry{}
+	for _, row := range rows {
+		entry := AuditEntry{Id: row.Id, PersonId: row.PersonId, Actor: row.Actor, Time: row.At.UTC(), Op: row.Op, Tenant: row.Tenant}
+		for _, value := range []struct {
+			column *string
+			person **Person
+		}{{row.Before, &entry.Before}, {row.After, &entry.After}} {
+			if value.column == nil {
+				continue
+			}
+			person := &Person{}
+			err := json.Unmarshal([]byte(*value.column), person)
+			if err != nil {
+				return nil, fmt.Errorf("audit entry %d: %v", row.Id, err)
+			}
+			*value.person = person
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
 }
 
-func (store *dbStore) deletePerson(id int) error {
-	person := Person{Id:id}
-	return store.db.Delete(&person).Error
+// dbError maps postgres and connection errors to the store errors
+func dbError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	// A canceled statement fails with a driver error, report why it was canceled
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		if errors.Is(err, ctxErr) {
+			return err
+		}
+		return fmt.Errorf("%w: %v", ctxErr, err)
+	}
+
+	if err == driver.ErrBadConn {
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	if _, ok := err.(net.Error); ok {
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+
+	// pq errors expose the SQLSTATE code through Get('C')
+	if pgErr, ok := err.(interface{ Get(k byte) string }); ok {
+		code := pgErr.Get('C')
+		switch {
+		case code == "23505":
+			return fmt.Errorf("%w: %v", ErrConflict, err)
+		case code == "23502" || code == "23514" || code == "22001":
+			return fmt.Errorf("%w: %v", ErrValidation, err)
+		case strings.HasPrefix(code, "08") || code == "57P01" || code == "57P03" || code == "53300":
+			return fmt.Errorf("%w: %v", ErrUnavailable, err)
+		}
+	}
+
+	// SQLite errors expose their extended result code through Code()
+	if sqliteErr, ok := err.(interface{ Code() int }); ok {
+		switch code := sqliteErr.Code(); {
+		case code == 2067 || code == 1555:
+			return fmt.Errorf("%w: %v", ErrConflict, err)
+		case code == 1299 || code == 275:
+			return fmt.Errorf("%w: %v", ErrValidation, err)
+		case code&0xff == 5 || code&0xff == 6 || code&0xff == 10:
+			return fmt.Errorf("%w: %v", ErrUnavailable, err)
+		}
+	}
+
+	return err
 }
 
 func SetupDbStorage() Store {
+	db, err := connectWithRetry(retryPolicy{
+		Timeout:     getEnvDuration("DB_CONNECT_TIMEOUT", time.Minute),
+		InitialWait: 500 * time.Millisecond,
+		MaxWait:     10 * time.Second,
+	}, openPostgres)
+	if err != nil {
+		panic(err)
+	}
+
+	err = prepareDb(db)
+	if err != nil {
+		panic(err)
+	}
+
+	return &dbStore{db}
+}
+
+// openPostgres connects to the database configured by the environment
+func openPostgres() (*gorm.DB, error) {
 	//Connect and set database store
 	host := getEnv("DB_HOST", "localhost")
 	port := getEnv("DB_PORT", "5432")
@@ -46,15 +640,18 @@ func SetupDbStorage() Store {
 	password := getEnv("DB_PASSWORD", "<wUA)dXRf6R\\8Z+P")
 	sslMode := getEnv("DB_SSL_MODE", "disable")
 	connString := "host=" + host + " port=" + port + " dbname=" + dbname + " user=" + user + " password=" + password + " sslmode=" + sslMode
-	db, err := gorm.Open("postgres", connString)
-
-	if err != nil {
-		panic(err)
-	}
+	return gorm.Open("postgres", connString)
+}
 
-	if !db.HasTable(&Person{}) {
-		db.CreateTable(&Person{})
+// prepareDb applies the pending migrations of a new connection, unless
+// DB_AUTO_MIGRATE is false and "migrate up" is run before deploying
+func prepareDb(db *gorm.DB) error {
+	if getEnv("DB_AUTO_MIGRATE", "true") == "false" {
+		return nil
 	}
+	return migrateUp(db)
+}
 
-	return &dbStore{db}
+func isPostgres(db *gorm.DB) bool {
+	return db.Dialect().GetName() == "postgres"
 }
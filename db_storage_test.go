@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestKeysetClause(t *testing.T) {
+	order := []SortField{{Field:"name", Descending:true}, {Field:"id"}}
+	key := Person{Id:3, Name:"Peter", PhoneNr:"24525345626"}
+
+	clause, args := keysetClause(order, key, false)
+
+	expectedClause := "(name < ?) OR (name = ? AND id > ?)"
+	if clause != expectedClause {
+		t.Errorf("keysetClause returned wrong clause: got %v want %v", clause, expectedClause)
+	}
+	expectedArgs := "[Peter Peter 3]"
+	if fmt.Sprint(args) != expectedArgs {
+		t.Errorf("keysetClause returned wrong args: got %v want %v", args, expectedArgs)
+	}
+
+	clause, _ = keysetClause(order, key, true)
+
+	expectedClause = "(name > ?) OR (name = ? AND id < ?)"
+	if clause != expectedClause {
+		t.Errorf("keysetClause returned wrong clause: got %v want %v", clause, expectedClause)
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	expected := `100\%\_a\\b`
+	if escaped := escapeLike(`100%_a\b`); escaped != expected {
+		t.Errorf("escapeLike returned wrong value: got %v want %v", escaped, expected)
+	}
+}
+
+func TestDbErrorMapsPostgresErrors(t *testing.T) {
+	for _, expected := range []struct {
+		code string
+		err  error
+	}{
+		{"23505", ErrConflict},
+		{"23502", ErrValidation},
+		{"22001", ErrValidation},
+		{"08006", ErrUnavailable},
+		{"57P01", ErrUnavailable},
+	} {
+		if err := dbError(context.Background(), &pq.Error{Code:pq.ErrorCode(expected.code)}); !errors.Is(err, expected.err) {
+			t.Errorf("dbError mapped SQLSTATE %v wrong: got %v want %v", expected.code, err, expected.err)
+		}
+	}
+
+	syntaxErr := &pq.Error{Code:"42601"}
+	if err := dbError(context.Background(), syntaxErr); err != syntaxErr {
+		t.Errorf("dbError changed an unknown error: got %v want %v", err, syntaxErr)
+	}
+}
+
+func newSqliteTestStore(t *testing.T) *dbStore {
+	db, err := openSqlite(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	err = prepareDb(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &dbStore{db}
+}
+
+func TestDbStoreChangesPeople(t *testing.T) {
+	sqliteStore := newSqliteTestStore(t)
+
+	p, err := sqliteStore.createPerson(context.Background(), Person{Name:"Peter", PhoneNr:"24525345626"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Id == 0 || p.Version != 1 {
+		t.Errorf("createPerson returned wrong id or version: %+v", p)
+	}
+
+	p.PhoneNr = "0791234567"
+	updated, err := sqliteStore.updatePerson(context.Background(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Person{Id:p.Id, Name:"Peter", PhoneNr:"0791234567", Version:2, Tenant:defaultTenant, CreatedAt:p.CreatedAt, UpdatedAt:updated.UpdatedAt}
+	if updated != expected {
+		t.Errorf("updatePerson returned wrong person: got %+v want %+v", updated, expected)
+	}
+
+	if _, err := sqliteStore.updatePerson(context.Background(), p); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("updatePerson with old version returned wrong error: got %v want %v", err, ErrVersionMismatch)
+	}
+	if _, err := sqliteStore.updatePerson(context.Background(), Person{Id:300, Name:"Paul", PhoneNr:"1"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("updatePerson of unknown id returned wrong error: got %v want %v", err, ErrNotFound)
+	}
+	if err := sqliteStore.deletePerson(context.Background(), p.Id, 1); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("deletePerson with old version returned wrong error: got %v want %v", err, ErrVersionMismatch)
+	}
+	if err := sqliteStore.deletePerson(context.Background(), p.Id, 2); err != nil {
+		t.Errorf("deletePerson returned error: %v", err)
+	}
+	if _, err := sqliteStore.getPerson(context.Background(), p.Id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("getPerson of deleted person returned wrong error: got %v want %v", err, ErrNotFound)
+	}
+	if err := sqliteStore.deletePerson(context.Background(), 0, 0); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deletePerson of id 0 returned wrong error: got %v want %v", err, ErrNotFound)
+	}
+}
+
+func TestDbStoreQueriesPeople(t *testing.T) {
+	sqliteStore := newSqliteTestStore(t)
+	for _, p := range []Person{{Name:"Peter", PhoneNr:"0791"}, {Name:"Paul", PhoneNr:"0792"}, {Name:"Alice", PhoneNr:"0791"}, {Name:"Peter", PhoneNr:"0441"}, {Name:"P_t%", PhoneNr:"0441"}} {
+		if _, err := sqliteStore.createPerson(context.Background(), p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	byName := []SortField{{Field:"name"}}
+	byNameDesc := []SortField{{Field:"name", Descending:true}}
+	tests := []struct {
+		query         PeopleQuery
+		expectedIds   []int
+		expectedTotal int
+	}{
+		{PeopleQuery{}, []int{1, 2, 3, 4, 5}, 5},
+		{PeopleQuery{Limit:2, Offset:3}, []int{4, 5}, 5},
+		{PeopleQuery{Name:"Peter"}, []int{1, 4}, 2},
+		{PeopleQuery{NamePrefix:"P_"}, []int{5}, 1},
+		{PeopleQuery{PhoneNrPrefix:"079"}, []int{1, 2, 3}, 3},
+		{PeopleQuery{Sort:byName}, []int{3, 5, 2, 1, 4}, 5},
+		{PeopleQuery{Sort:byNameDesc, Limit:2}, []int{1, 4}, 5},
+		{PeopleQuery{Sort:byName, Limit:2, After:&Person{Id:2, Name:"Paul"}}, []int{1, 4}, 5},
+		{PeopleQuery{Sort:byNameDesc, Limit:2, Before:&Person{Id:3, Name:"Alice"}}, []int{2, 5}, 5},
+	}
+
+	for _, test := range tests {
+		people, total, err := sqliteStore.getPeople(context.Background(), test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total != test.expectedTotal {
+			t.Errorf("getPeople(%+v) returned wrong total: got %v want %v", test.query, total, test.expectedTotal)
+		}
+		ids := []int{}
+		for _, person := range people {
+			ids = append(ids, person.Id)
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(test.expectedIds) {
+			t.Errorf("getPeople(%+v) returned wrong ids: got %v want %v", test.query, ids, test.expectedIds)
+		}
+	}
+
+	results, err := sqliteStore.searchPeople(context.Background(), "Petr", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Id != 1 || results[1].Id != 4 {
+		t.Errorf("searchPeople returned wrong results: %+v", results)
+	}
+}
+
+func TestDbStorePassesContextToDatabase(t *testing.T) {
+	sqliteStore := newSqliteTestStore(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := sqliteStore.createPerson(ctx, Person{Name:"Peter", PhoneNr:"24525345626"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("createPerson returned wrong error: got %v want %v", err, context.Canceled)
+	}
+	if _, err := sqliteStore.getPerson(ctx, 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("getPerson returned wrong error: got %v want %v", err, context.Canceled)
+	}
+
+	_, total, err := sqliteStore.getPeople(context.Background(), PeopleQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 0 {
+		t.Errorf("createPerson stored a person with a canceled context: got %v people", total)
+	}
+}
+
+func TestDbStorePingChecksMigrations(t *testing.T) {
+	sqliteStore := newSqliteTestStore(t)
+
+	if err := sqliteStore.ping(context.Background()); err != nil {
+		t.Errorf("ping failed on a migrated database: %v", err)
+	}
+
+	if err := migrateDown(sqliteStore.db); err != nil {
+		t.Fatal(err)
+	}
+	if err := sqliteStore.ping(context.Background()); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("ping returned wrong error with a pending migration: got %v want %v", err, ErrUnavailable)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// etag returns the strong entity tag of the current version of p
+func etag(p Person) string {
+	return `"` + strconv.Itoa(p.Version) + `"`
+}
+
+// setValidators sets the ETag and Last-Modified headers of p. People
+// stored before the stores kept timestamps have no Last-Modified.
+func setValidators(w http.ResponseWriter, p Person) {
+	w.Header().Set("ETag", etag(p))
+	if !p.UpdatedAt.IsZero() {
+		w.Header().Set("Last-Modified", p.UpdatedAt.UTC().Format(http.TimeFormat))
+	}
+}
+
+// notModified evaluates If-None-Match, or If-Modified-Since if there is no
+// If-None-Match, and reports whether the client's copy of p is current
+func notModified(r *http.Request, p Person) bool {
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		return matchesETag(parseETags(ifNoneMatch), etag(p), true)
+	}
+
+	ifModifiedSince, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || p.UpdatedAt.IsZero() {
+		return false
+	}
+	// HTTP dates have a precision of seconds
+	return !p.UpdatedAt.Truncate(time.Second).After(ifModifiedSince)
+}
+
+// parseETags splits an If-Match or If-None-Match header into entity tags
+func parseETags(header string) []string {
+	tags := []string{}
+	for _, tag := range strings.Split(header, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// matchesETag reports whether one of tags matches current. The strong
+// comparison never matches weak tags, the weak comparison ignores the W/.
+func matchesETag(tags []string, current string, weak bool) bool {
+	for _, tag := range tags {
+		if tag == "*" {
+			return true
+		}
+		if weak {
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		if tag == current {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch evaluates the If-Match header against the stored person and
+// returns the version the change has to be made on, 0 for an unconditional
+// change. If false is returned the response has already been written.
+func checkIfMatch(w http.ResponseWriter, r *http.Request, id int) (int, bool) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return 0, true
+	}
+
+	tags := parseETags(header)
+	if len(tags) == 1 && tags[0] == "*" {
+		return 0, true
+	}
+
+	person, err := store.getPerson(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, r, err, "Could not load person "+strconv.Itoa(id))
+		return 0, false
+	}
+
+	if !matchesETag(tags, etag(person), false) {
+		writePreconditionFailed(w, r, person)
+		return 0, false
+	}
+	return person.Version, true
+}
+
+// ifMatchVersion returns the version the If-Match header asks for without
+// loading the person, for people getPerson does not return. A header that
+// is not a single strong entity tag of a version matches no version.
+func ifMatchVersion(r *http.Request) int {
+	header := r.Header.Get("If-Match")
+	if header == "" || header == "*" {
+		return 0
+	}
+
+	tags := parseETags(header)
+	if len(tags) == 1 && strings.HasPrefix(tags[0], `"`) && strings.HasSuffix(tags[0], `"`) {
+		if version, err := strconv.Atoi(strings.Trim(tags[0], `"`)); err == nil && version > 0 {
+			return version
+		}
+	}
+	return -1
+}
+
+func writePreconditionFailed(w http.ResponseWriter, r *http.Request, current Person) {
+	w.Header().Set("ETag", etag(current))
+	writeError(w, r, http.StatusPreconditionFailed, "Person "+strconv.Itoa(current.Id)+" was modified, the current ETag is "+etag(current))
+}
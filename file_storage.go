@@ -0,0 +1,594 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SyncPolicy decides when the log of a FileStore is flushed to disk
+type SyncPolicy string
+
+const (
+	// SyncAlways flushes every change before it is acknowledged
+	SyncAlways SyncPolicy = "always"
+	// SyncInterval flushes in the background, a crash loses at most one interval
+	SyncInterval SyncPolicy = "interval"
+	// SyncNever leaves flushing to the operating system
+	SyncNever SyncPolicy = "never"
+)
+
+const (
+	logFileName      = "people.log"
+	snapshotFileName = "people.snapshot"
+)
+
+type FileStoreOptions struct {
+	Dir          string
+	Sync         SyncPolicy
+	SyncInterval time.Duration
+	// CompactEvery is the number of logged changes after which a snapshot
+	// is written and the log is emptied, 0 only compacts on Close
+	CompactEvery int
+}
+
+// FileStore keeps people in memory and persists every change to an
+// append-only log in a data directory. The log is compacted into a
+// snapshot from time to time, on startup the snapshot is loaded and the
+// log replayed on top of it.
+type FileStore struct {
+	// lock serializes changes, so the log has the order they were applied in
+	lock     sync.Mutex
+	memory   *MemoryStore
+	options  FileStoreOptions
+	log      *os.File
+	logSize  int64
+	logged   int
+	stopSync chan struct{}
+	syncDone chan struct{}
+}
+
+// logRecord is one change in the log. Every record holds the resulting
+// state, so replaying a record a second time has no further effect. People
+// logged without tenant belong to the default tenant. A deleted person is
+// put with its deletedAt, the delete op removes it for good.
+type logRecord struct {
+	Op      string       `json:"op"`
+	Person  *Person      `json:"person,omitempty"`
+	Version int          `json:"version,omitempty"`
+	Tenant  string       `json:"tenant,omitempty"`
+	Id      int          `json:"id,omitempty"`
+	Audit   *auditRecord `json:"audit,omitempty"`
+}
+
+// auditRecord is an audit entry in the log or snapshot together with its
+// tenant, which the API does not show
+type auditRecord struct {
+	AuditEntry
+	Tenant string `json:"tenant"`
+}
+
+func newAuditRecord(entry AuditEntry) *auditRecord {
+	return &auditRecord{entry, entry.Tenant}
+}
+
+func (record auditRecord) entry() AuditEntry {
+	entry := record.AuditEntry
+	entry.Tenant = record.Tenant
+	return entry
+}
+
+const (
+	opPut    = "put"
+	opDelete = "delete"
+)
+
+type snapshot struct {
+	NextId int           `json:"nextId"`
+	People []logRecord   `json:"people"`
+	Audit  []auditRecord `json:"audit,omitempty"`
+}
+
+// OpenFileStore loads the people stored in options.Dir, creating the
+// directory if needed
+func OpenFileStore(options FileStoreOptions) (*FileStore, error) {
+	if options.Sync == "" {
+		options.Sync = SyncAlways
+	}
+	if options.Sync != SyncAlways && options.Sync != SyncInterval && options.Sync != SyncNever {
+		return nil, fmt.Errorf("unknown sync policy %q", options.Sync)
+	}
+	if options.SyncInterval <= 0 {
+		options.SyncInterval = time.Second
+	}
+
+	err := os.MkdirAll(options.Dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &FileStore{memory: NewMemoryStore(), options: options}
+
+	err = store.loadSnapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	store.log, err = os.OpenFile(filepath.Join(options.Dir, logFileName), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	err = store.replayLog()
+	if err != nil {
+		store.log.Close()
+		return nil, err
+	}
+
+	if options.Sync == SyncInterval {
+		store.stopSync = make(chan struct{})
+		store.syncDone = make(chan struct{})
+		go store.syncPeriodically()
+	}
+
+	return store, nil
+}
+
+// SetupFileStorage opens the file store configured by the environment:
+// DATA_DIR, DATA_SYNC with the SyncPolicy, DATA_SYNC_INTERVAL with the flush
+// period of the interval policy, and DATA_COMPACT_EVERY
+func SetupFileStorage() Store {
+	compactEvery, err := strconv.Atoi(getEnv("DATA_COMPACT_EVERY", "1000"))
+	if err != nil {
+		panic(err)
+	}
+
+	store, err := OpenFileStore(FileStoreOptions{
+		Dir:          getEnv("DATA_DIR", "data"),
+		Sync:         SyncPolicy(getEnv("DATA_SYNC", string(SyncAlways))),
+		SyncInterval: getEnvDuration("DATA_SYNC_INTERVAL", time.Second),
+		CompactEvery: compactEvery,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return store
+}
+
+func (store *FileStore) getPeople(ctx context.Context, query PeopleQuery) ([]Person, int, error) {
+	return store.memory.getPeople(ctx, query)
+}
+
+func (store *FileStore) getPerson(ctx context.Context, id int) (Person, error) {
+	return store.memory.getPerson(ctx, id)
+}
+
+func (store *FileStore) searchPeople(ctx context.Context, q string, limit int) ([]SearchResult, error) {
+	return store.memory.searchPeople(ctx, q, limit)
+}
+
+func (store *FileStore) getAudit(ctx context.Context, query AuditQuery) ([]AuditEntry, error) {
+	return store.memory.getAudit(ctx, query)
+}
+
+func (store *FileStore) createPerson(ctx context.Context, p Person) (Person, error) {
+	if err := ctx.Err(); err != nil {
+		return Person{}, err
+	}
+	if err := checkPerson(p); err != nil {
+		return Person{}, err
+	}
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	p.Tenant = tenantFrom(ctx)
+	if err := quotas.exceeded(p.Tenant, store.memory.count(p.Tenant)); err != nil {
+		return Person{}, err
+	}
+	p.Id = store.memory.nextId()
+	p.Version = 1
+	p.CreatedAt = storeTime()
+	p.UpdatedAt = p.CreatedAt
+	p.DeletedAt = nil
+	entry := store.auditEntry(ctx, auditCreate, p.CreatedAt, nil, &p)
+	err := store.append(logRecord{Op: opPut, Person: &p, Version: p.Version, Tenant: p.Tenant, Audit: newAuditRecord(entry)})
+	if err != nil {
+		return Person{}, err
+	}
+	store.memory.put(p)
+	store.memory.addAudit(entry)
+	store.compactIfDue()
+	return p, nil
+}
+
+func (store *FileStore) updatePerson(ctx context.Context, p Person) (Person, error) {
+	if err := checkPerson(p); err != nil {
+		return Person{}, err
+	}
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	old, err := store.memory.getPerson(ctx, p.Id)
+	if err != nil {
+		return Person{}, err
+	}
+	if p.Version != 0 && p.Version != old.Version {
+		return Person{}, ErrVersionMismatch
+	}
+	p.Version = old.Version + 1
+	p.Tenant = old.Tenant
+	p.CreatedAt = old.CreatedAt
+	p.UpdatedAt = storeTime()
+	p.DeletedAt = nil
+
+	entry := store.auditEntry(ctx, auditUpdate, p.UpdatedAt, &old, &p)
+	err = store.append(logRecord{Op: opPut, Person: &p, Version: p.Version, Tenant: p.Tenant, Audit: newAuditRecord(entry)})
+	if err != nil {
+		return Person{}, err
+	}
+	store.memory.put(p)
+	store.memory.addAudit(entry)
+	store.compactIfDue()
+	return p, nil
+}
+
+func (store *FileStore) deletePerson(ctx context.Context, id int, version int) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	old, err := store.memory.getPerson(ctx, id)
+	if err != nil {
+		return err
+	}
+	if version != 0 && version != old.Version {
+		return ErrVersionMismatch
+	}
+
+	p := old
+	deletedAt := storeTime()
+	p.DeletedAt = &deletedAt
+	p.UpdatedAt = deletedAt
+	p.Version = old.Version + 1
+
+	entry := store.auditEntry(ctx, auditDelete, deletedAt, &old, nil)
+	err = store.append(logRecord{Op: opPut, Person: &p, Version: p.Version, Tenant: p.Tenant, Audit: newAuditRecord(entry)})
+	if err != nil {
+		return err
+	}
+	store.memory.put(p)
+	store.memory.addAudit(entry)
+	store.compactIfDue()
+	return nil
+}
+
+func (store *FileStore) restorePerson(ctx context.Context, id int, version int) (Person, error) {
+	if err := ctx.Err(); err != nil {
+		return Person{}, err
+	}
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	old, err := store.memory.getDeleted(ctx, id)
+	if err != nil {
+		return Person{}, err
+	}
+	if version != 0 && version != old.Version {
+		return Person{}, ErrVersionMismatch
+	}
+	if err := quotas.exceeded(old.Tenant, store.memory.count(old.Tenant)); err != nil {
+		return Person{}, err
+	}
+	p := old
+	p.DeletedAt = nil
+	p.UpdatedAt = storeTime()
+	p.Version = old.Version + 1
+
+	entry := store.auditEntry(ctx, auditRestore, p.UpdatedAt, nil, &p)
+	err = store.append(logRecord{Op: opPut, Person: &p, Version: p.Version, Tenant: p.Tenant, Audit: newAuditRecord(entry)})
+	if err != nil {
+		return Person{}, err
+	}
+	store.memory.put(p)
+	store.memory.addAudit(entry)
+	store.compactIfDue()
+	return p, nil
+}
+
+func (store *FileStore) purgeDeleted(ctx context.Context, before time.Time) (int, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	purged := 0
+	for _, p := range store.memory.expiredPeople(before) {
+		if err := ctx.Err(); err != nil {
+			return purged, err
+		}
+
+		entry := newPurgeEntry(p)
+		entry.Id = store.memory.nextAuditId()
+		err := store.append(logRecord{Op: opDelete, Id: p.Id, Audit: newAuditRecord(entry)})
+		if err != nil {
+			return purged, err
+		}
+		store.memory.remove(p.Id)
+		store.memory.addAudit(entry)
+		purged++
+	}
+	store.compactIfDue()
+	return purged, nil
+}
+
+// auditEntry returns the entry of a change with the id it is logged with,
+// the lock must be held
+func (store *FileStore) auditEntry(ctx context.Context, op string, at time.Time, before *Person, after *Person) AuditEntry {
+	entry := newAuditEntry(ctx, op, at, before, after)
+	entry.Id = store.memory.nextAuditId()
+	return entry
+}
+
+// ping checks that the log is still open
+func (store *FileStore) ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	if _, err := store.log.Stat(); err != nil {
+		return fmt.Errorf("%w: log: %v", ErrUnavailable, err)
+	}
+	return nil
+}
+
+// Close compacts the log and releases the files
+func (store *FileStore) Close() error {
+	if store.stopSync != nil {
+		close(store.stopSync)
+		<-store.syncDone
+	}
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	err := store.compact()
+	if closeErr := store.log.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// append writes a record to the log, the lock must be held. Each line is
+// the CRC-32 of the record followed by the record as JSON.
+func (store *FileStore) append(record logRecord) error {
+	recordBytes, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line := fmt.Sprintf("%08x %s\n", crc32.ChecksumIEEE(recordBytes), recordBytes)
+
+	_, err = store.log.WriteString(line)
+	if err == nil && store.options.Sync == SyncAlways {
+		err = store.log.Sync()
+	}
+	if err != nil {
+		// Cut off a partly written record, later records would follow it
+		store.log.Truncate(store.logSize)
+		return fmt.Errorf("%w: writing log: %v", ErrUnavailable, err)
+	}
+
+	store.logSize += int64(len(line))
+	store.logged++
+	return nil
+}
+
+// compactIfDue compacts the log once enough changes were logged, the lock
+// must be held. The change is already durable, so a failed compaction is
+// not reported and tried again with the next change.
+func (store *FileStore) compactIfDue() {
+	if store.options.CompactEvery > 0 && store.logged >= store.options.CompactEvery {
+		store.compact()
+	}
+}
+
+// compact writes all people to a new snapshot and empties the log, the
+// lock must be held. Should the process die in between, the log is
+// replayed onto the new snapshot without changing it.
+func (store *FileStore) compact() error {
+	current := snapshot{NextId: store.memory.nextId(), People: []logRecord{}}
+	for _, person := range store.memory.all() {
+		p := person
+		current.People = append(current.People, logRecord{Op: opPut, Person: &p, Version: p.Version, Tenant: p.Tenant})
+	}
+	for _, entry := range store.memory.auditTrail() {
+		current.Audit = append(current.Audit, *newAuditRecord(entry))
+	}
+
+	snapshotBytes, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(store.options.Dir, snapshotFileName)
+	err = writeFileSynced(path+".tmp", snapshotBytes)
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err == nil {
+		err = syncDir(store.options.Dir)
+	}
+	if err == nil {
+		err = store.log.Truncate(0)
+	}
+	if err == nil {
+		err = store.log.Sync()
+	}
+	if err != nil {
+		return fmt.Errorf("%w: compacting log: %v", ErrUnavailable, err)
+	}
+
+	store.logSize = 0
+	store.logged = 0
+	return nil
+}
+
+func (store *FileStore) loadSnapshot() error {
+	snapshotBytes, err := os.ReadFile(filepath.Join(store.options.Dir, snapshotFileName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	loaded := snapshot{}
+	err = json.Unmarshal(snapshotBytes, &loaded)
+	if err != nil {
+		return fmt.Errorf("reading snapshot: %v", err)
+	}
+
+	for _, record := range loaded.People {
+		store.apply(record)
+	}
+	for _, record := range loaded.Audit {
+		store.memory.addAudit(record.entry())
+	}
+	store.memory.lock.Lock()
+	if loaded.NextId > store.memory.id {
+		store.memory.id = loaded.NextId
+	}
+	store.memory.lock.Unlock()
+	return nil
+}
+
+// replayLog applies all records of the log. A damaged record at the end
+// was not completely written before a crash and is cut off, damage in any
+// other place is reported as an error.
+func (store *FileStore) replayLog() error {
+	_, err := store.log.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(store.log)
+	offset := int64(0)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		record, parseErr := parseLogLine(line)
+		if parseErr != nil {
+			if _, peekErr := reader.Peek(1); peekErr == io.EOF {
+				return store.log.Truncate(offset)
+			}
+			return fmt.Errorf("corrupt log record at offset %d: %v", offset, parseErr)
+		}
+
+		store.apply(record)
+		store.logged++
+		offset += int64(len(line))
+		store.logSize = offset
+	}
+}
+
+func parseLogLine(line []byte) (logRecord, error) {
+	record := logRecord{}
+	if !bytes.HasSuffix(line, []byte("\n")) {
+		return record, fmt.Errorf("incomplete record")
+	}
+
+	fields := bytes.SplitN(bytes.TrimSuffix(line, []byte("\n")), []byte(" "), 2)
+	if len(fields) != 2 {
+		return record, fmt.Errorf("missing checksum")
+	}
+	checksum, err := strconv.ParseUint(string(fields[0]), 16, 32)
+	if err != nil || uint32(checksum) != crc32.ChecksumIEEE(fields[1]) {
+		return record, fmt.Errorf("checksum mismatch")
+	}
+
+	err = json.Unmarshal(fields[1], &record)
+	if err != nil {
+		return record, err
+	}
+	if (record.Op == opPut && record.Person == nil) || (record.Op != opPut && record.Op != opDelete) {
+		return record, fmt.Errorf("invalid record %s", fields[1])
+	}
+	return record, nil
+}
+
+func (store *FileStore) apply(record logRecord) {
+	if record.Audit != nil {
+		store.memory.addAudit(record.Audit.entry())
+	}
+	if record.Op == opDelete {
+		store.memory.remove(record.Id)
+		return
+	}
+	p := *record.Person
+	p.Version = record.Version
+	p.Tenant = record.Tenant
+	if p.Tenant == "" {
+		p.Tenant = defaultTenant
+	}
+	store.memory.put(p)
+}
+
+func (store *FileStore) syncPeriodically() {
+	defer close(store.syncDone)
+
+	ticker := time.NewTicker(store.options.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-store.stopSync:
+			return
+		case <-ticker.C:
+			store.lock.Lock()
+			store.log.Sync()
+			store.lock.Unlock()
+		}
+	}
+}
+
+func writeFileSynced(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// syncDir makes a rename in dir durable
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStoreRecoversPeople(t *testing.T) {
+	dir := t.TempDir()
+
+	fileStore, err := OpenFileStore(FileStoreOptions{Dir:dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peter, err := fileStore.createPerson(context.Background(), Person{Name:"Peter", PhoneNr:"24525345626"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	paul, err := fileStore.createPerson(context.Background(), Person{Name:"Paul", PhoneNr:"643265776357948984"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	peter.PhoneNr = "0791234567"
+	updated, err := fileStore.updatePerson(context.Background(), peter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fileStore.deletePerson(context.Background(), paul.Id, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopen without Close, so the state has to come from the log
+	fileStore.log.Close()
+	fileStore, err = OpenFileStore(FileStoreOptions{Dir:dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fileStore.Close()
+
+	recovered, err := fileStore.getPerson(context.Background(), peter.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Person{Id:1, Name:"Peter", PhoneNr:"0791234567", Version:2, Tenant:defaultTenant, CreatedAt:peter.CreatedAt, UpdatedAt:updated.UpdatedAt}
+	if recovered != expected {
+		t.Errorf("getPerson returned wrong person: got %+v want %+v", recovered, expected)
+	}
+	if _, err := fileStore.getPerson(context.Background(), paul.Id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("getPerson returned deleted person: %v", err)
+	}
+
+	created, err := fileStore.createPerson(context.Background(), Person{Name:"Alice", PhoneNr:"12343463462345243"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Id != 3 {
+		t.Errorf("createPerson reused an id: got %v want %v", created.Id, 3)
+	}
+}
+
+func TestFileStoreDropsTruncatedFinalRecord(t *testing.T) {
+	dir := t.TempDir()
+
+	fileStore, err := OpenFileStore(FileStoreOptions{Dir:dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fileStore.createPerson(context.Background(), Person{Name:"Peter", PhoneNr:"24525345626"}); err != nil {
+		t.Fatal(err)
+	}
+	fileStore.log.Close()
+
+	// Simulate a crash in the middle of writing the next record
+	logPath := filepath.Join(dir, logFileName)
+	logFile, err := os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logFile.WriteString(`1a2b3c4d {"op":"put","person":{"id":2,"na`)
+	logFile.Close()
+
+	fileStore, err = OpenFileStore(FileStoreOptions{Dir:dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	people, total, err := fileStore.getPeople(context.Background(), PeopleQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 1 || people[0].Name != "Peter" {
+		t.Errorf("getPeople returned wrong people after recovery: %+v", people)
+	}
+
+	// Records written after the recovery must still be readable
+	if _, err := fileStore.createPerson(context.Background(), Person{Name:"Paul", PhoneNr:"643265776357948984"}); err != nil {
+		t.Fatal(err)
+	}
+	fileStore.log.Close()
+
+	fileStore, err = OpenFileStore(FileStoreOptions{Dir:dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fileStore.Close()
+
+	if _, total, _ := fileStore.getPeople(context.Background(), PeopleQuery{}); total != 2 {
+		t.Errorf("getPeople returned wrong total: got %v want %v", total, 2)
+	}
+}
+
+func TestFileStoreRejectsCorruptRecord(t *testing.T) {
+	dir := t.TempDir()
+
+	logPath := filepath.Join(dir, logFileName)
+	content := "00000000 {\"op\":\"delete\",\"id\":1}\n" +
+		"7b0a6b63 {\"op\":\"delete\",\"id\":2}\n"
+	if err := os.WriteFile(logPath, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenFileStore(FileStoreOptions{Dir:dir}); err == nil {
+		t.Errorf("OpenFileStore accepted a corrupt record in the middle of the log")
+	}
+}
+
+func TestFileStoreCompactsLog(t *testing.T) {
+	dir := t.TempDir()
+
+	fileStore, err := OpenFileStore(FileStoreOptions{Dir:dir, Sync:SyncNever, CompactEvery:3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"Peter", "Paul", "Alice", "Bob"} {
+		if _, err := fileStore.createPerson(context.Background(), Person{Name:name, PhoneNr:"24525345626"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fileStore.deletePerson(context.Background(), 4, 0); err != nil {
+		t.Fatal(err)
+	}
+	fileStore.log.Close()
+
+	if _, err := os.Stat(filepath.Join(dir, snapshotFileName)); err != nil {
+		t.Errorf("no snapshot was written: %v", err)
+	}
+	logInfo, err := os.Stat(filepath.Join(dir, logFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logInfo.Size() == 0 {
+		t.Errorf("log is empty, but the changes after the snapshot must be logged")
+	}
+
+	fileStore, err = OpenFileStore(FileStoreOptions{Dir:dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	people, total, err := fileStore.getPeople(context.Background(), PeopleQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 3 || people[2].Name != "Alice" {
+		t.Errorf("getPeople returned wrong people after compaction: %+v", people)
+	}
+
+	if err := fileStore.Close(); err != nil {
+		t.Fatal(err)
+	}
+	logInfo, err = os.Stat(filepath.Join(dir, logFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logInfo.Size() != 0 {
+		t.Errorf("Close did not compact the log, size is %v", logInfo.Size())
+	}
+}
+
+func TestFileStorePingFailsAfterClose(t *testing.T) {
+	fileStore, err := OpenFileStore(FileStoreOptions{Dir:t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fileStore.ping(context.Background()); err != nil {
+		t.Errorf("ping failed on an open store: %v", err)
+	}
+
+	if err := fileStore.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fileStore.ping(context.Background()); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("ping returned wrong error after Close: got %v want %v", err, ErrUnavailable)
+	}
+}
+
+// reopenFileStore runs check on fileStore reopened from dir, first from
+// the log alone and then from the snapshot Close writes, and closes it
+func reopenFileStore(t *testing.T, fileStore *FileStore, dir string, check func(*FileStore)) {
+	for _, compact := range []bool{false, true} {
+		var err error
+		if compact {
+			err = fileStore.Close()
+		} else {
+			err = fileStore.log.Close()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		fileStore, err = OpenFileStore(FileStoreOptions{Dir:dir})
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(fileStore)
+	}
+	fileStore.Close()
+}
+
+func TestSetupFileStorageReadsSyncInterval(t *testing.T) {
+	t.Setenv("DATA_DIR", t.TempDir())
+	t.Setenv("DATA_SYNC", string(SyncInterval))
+	t.Setenv("DATA_SYNC_INTERVAL", "250ms")
+
+	fileStore := SetupFileStorage().(*FileStore)
+	defer fileStore.Close()
+
+	if fileStore.options.Sync != SyncInterval || fileStore.options.SyncInterval != 250*time.Millisecond {
+		t.Errorf("SetupFileStorage used wrong sync options: got %v every %v", fileStore.options.Sync, fileStore.options.SyncInterval)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// healthCheckTimeout bounds the store check of a readiness probe
+const healthCheckTimeout = 2 * time.Second
+
+// draining is set once the server shuts down, so readiness fails and no
+// new requests are routed to it
+var draining atomic.Bool
+
+// HealthStatus is the body of the health endpoints. Status is "ok" or
+// "unavailable", Components lists the checks that were made.
+type HealthStatus struct {
+	Status     string            `json:"status"`
+	Components []ComponentStatus `json:"components,omitempty"`
+}
+
+type ComponentStatus struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+const (
+	statusOk          = "ok"
+	statusUnavailable = "unavailable"
+)
+
+// Healthz answers as long as the process is able to handle requests
+func Healthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, HealthStatus{Status: statusOk})
+}
+
+// Readyz reports whether requests can be served: the server is not
+// shutting down and the store is reachable
+func Readyz(w http.ResponseWriter, r *http.Request) {
+	health := HealthStatus{Status: statusOk}
+
+	server := ComponentStatus{Name: "server", Status: statusOk}
+	if draining.Load() {
+		server = ComponentStatus{Name: "server", Status: statusUnavailable, Detail: "shutting down"}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+	defer cancel()
+	storage := ComponentStatus{Name: "store", Status: statusOk}
+	if err := store.ping(ctx); err != nil {
+		storage = ComponentStatus{Name: "store", Status: statusUnavailable, Detail: err.Error()}
+	}
+
+	health.Components = []ComponentStatus{server, storage}
+	for _, component := range health.Components {
+		if component.Status != statusOk {
+			health.Status = statusUnavailable
+		}
+	}
+	writeHealth(w, health)
+}
+
+func writeHealth(w http.ResponseWriter, health HealthStatus) {
+	healthBytes, err := json.Marshal(health)
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if health.Status != statusOk {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write(healthBytes)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// jsonWebKey is a key of a JWKS file (RFC 7517), symmetric keys use k,
+// RSA keys n and e
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	K   string `json:"k"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// verificationKey is a key together with the only algorithm it may verify,
+// so a token cannot pick an algorithm the key was not meant for
+type verificationKey struct {
+	id  string
+	alg string
+	key interface{}
+}
+
+// jwtAuthenticator accepts HS256 and RS256 bearer tokens signed with a key
+// of a local JWKS file
+type jwtAuthenticator struct {
+	keys     []verificationKey
+	issuer   string
+	audience string
+}
+
+// tokenClaims are the claims read from a token. Roles are a list, scopes
+// a space separated string as in OAuth 2.0.
+type tokenClaims struct {
+	jwt.RegisteredClaims
+	Roles  []string `json:"roles,omitempty"`
+	Scope  string   `json:"scope,omitempty"`
+	Tenant string   `json:"tenant,omitempty"`
+}
+
+// loadJWKS reads the oct and RSA keys of a JWKS file
+func loadJWKS(path string) (*jwtAuthenticator, error) {
+	jwksBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	jwks := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	err = json.Unmarshal(jwksBytes, &jwks)
+	if err != nil {
+		return nil, fmt.Errorf("reading JWKS: %v", err)
+	}
+
+	authenticator := &jwtAuthenticator{}
+	for i, webKey := range jwks.Keys {
+		key, err := parseWebKey(webKey)
+		if err != nil {
+			return nil, fmt.Errorf("JWKS key %d: %v", i+1, err)
+		}
+		authenticator.keys = append(authenticator.keys, key)
+	}
+	if len(authenticator.keys) == 0 {
+		return nil, fmt.Errorf("JWKS %s has no keys", path)
+	}
+	return authenticator, nil
+}
+
+func parseWebKey(webKey jsonWebKey) (verificationKey, error) {
+	key := verificationKey{id: webKey.Kid}
+	switch webKey.Kty {
+	case "oct":
+		secret, err := base64.RawURLEncoding.DecodeString(webKey.K)
+		if err != nil || len(secret) < 32 {
+			return key, fmt.Errorf("k must be a base64url encoded secret of at least 32 bytes")
+		}
+		key.alg, key.key = "HS256", secret
+	case "RSA":
+		n, errN := base64.RawURLEncoding.DecodeString(webKey.N)
+		e, errE := base64.RawURLEncoding.DecodeString(webKey.E)
+		if errN != nil || errE != nil || len(n) == 0 || len(e) == 0 {
+			return key, fmt.Errorf("n and e must be base64url encoded")
+		}
+		key.alg = "RS256"
+		key.key = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	default:
+		return key, fmt.Errorf("unsupported key type %q", webKey.Kty)
+	}
+
+	if webKey.Alg != "" && webKey.Alg != key.alg {
+		return key, fmt.Errorf("%s keys are only supported for %s, not %s", webKey.Kty, key.alg, webKey.Alg)
+	}
+	return key, nil
+}
+
+func (tokens *jwtAuthenticator) authenticate(r *http.Request) (Principal, error) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return Principal{}, errNoCredentials
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"HS256", "RS256"}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(30 * time.Second),
+	}
+	if tokens.issuer != "" {
+		options = append(options, jwt.WithIssuer(tokens.issuer))
+	}
+	if tokens.audience != "" {
+		options = append(options, jwt.WithAudience(tokens.audience))
+	}
+
+	claims := &tokenClaims{}
+	_, err := jwt.ParseWithClaims(strings.TrimSpace(token), claims, tokens.key, options...)
+	if err != nil {
+		return Principal{}, err
+	}
+	if claims.Subject == "" {
+		return Principal{}, fmt.Errorf("token has no subject")
+	}
+	if claims.Tenant != "" && !validTenant(claims.Tenant) {
+		return Principal{}, fmt.Errorf("token has an invalid tenant")
+	}
+	return Principal{Subject: claims.Subject, Roles: claims.Roles, Scopes: strings.Fields(claims.Scope), Tenant: claims.Tenant, Method: "jwt"}, nil
+}
+
+// key returns the key matching the kid and the algorithm of token. A token
+// without kid is verified with the first key of its algorithm.
+func (tokens *jwtAuthenticator) key(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	alg := token.Method.Alg()
+	for _, key := range tokens.keys {
+		if key.alg == alg && (kid == "" || key.id == kid) {
+			return key.key, nil
+		}
+	}
+	return nil, fmt.Errorf("no %s key with id %q", alg, kid)
+}
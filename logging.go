@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// logger writes JSON lines, main configures the level with LOG_LEVEL
+var logger = newLogger(os.Stderr, slog.LevelInfo)
+
+func newLogger(out io.Writer, level slog.Level) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(out, &slog.HandlerOptions{Level: level}))
+}
+
+// parseLogLevel reads debug, info, warn or error
+func parseLogLevel(value string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(value))
+	return level, err
+}
+
+// fatal logs err and exits, for errors the service cannot start with
+func fatal(message string, err error) {
+	logger.Error(message, "error", err)
+	os.Exit(1)
+}
+
+const requestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// newRequestID returns a random id for a request that did not bring one
+var newRequestID = func() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// validRequestID accepts ids of visible ASCII characters, so a client
+// cannot break up log lines or headers
+func validRequestID(id string) bool {
+	if len(id) == 0 || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// requestID returns the id of the request ctx belongs to
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// loggerFrom returns the logger for the request ctx belongs to, it adds
+// the request id and the trace id to every line
+func loggerFrom(ctx context.Context) *slog.Logger {
+	requestLogger := logger
+	if id := requestID(ctx); id != "" {
+		requestLogger = requestLogger.With("requestId", id)
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		requestLogger = requestLogger.With("traceId", span.TraceID().String())
+	}
+	return requestLogger
+}
+
+// logRequests takes the X-Request-ID of a request or creates one, sends it
+// back and writes an access log line once the request is handled
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+
+		route := ""
+		if current := mux.CurrentRoute(r); current != nil {
+			route, _ = current.GetPathTemplate()
+		}
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+
+		// Probes and scrapes would drown the other requests
+		level := slog.LevelInfo
+		if route == "/healthz" || route == "/readyz" || route == "/metrics" {
+			level = slog.LevelDebug
+		}
+		loggerFrom(r.Context()).Log(r.Context(), level, "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"route", route,
+			"status", recorder.status,
+			"bytes", recorder.bytes,
+			"durationMs", float64(time.Since(start).Microseconds())/1000,
+			"remoteAddr", r.RemoteAddr,
+			"userAgent", r.UserAgent(),
+		)
+	})
+}
+
+// logStoreError logs the cause of a failed store operation before it is
+// turned into a response. Errors the client caused are only debug output.
+func logStoreError(r *http.Request, err error, detail string) {
+	level := slog.LevelDebug
+	switch errorKind(err) {
+	case "other":
+		level = slog.LevelError
+	case "unavailable", "timeout":
+		level = slog.LevelWarn
+	case "canceled":
+		level = slog.LevelInfo
+	}
+	loggerFrom(r.Context()).Log(r.Context(), level, detail, "error", err.Error(), "kind", errorKind(err))
+}
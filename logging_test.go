@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+)
+
+// captureLogs sends the log lines to a buffer until the test ends
+func captureLogs(t *testing.T, level slog.Level) *bytes.Buffer {
+	previous := logger
+	t.Cleanup(func() { logger = previous })
+
+	lines := &bytes.Buffer{}
+	logger = newLogger(lines, level)
+	return lines
+}
+
+// logLines decodes the JSON lines written to lines
+func logLines(t *testing.T, lines *bytes.Buffer) []map[string]interface{} {
+	entries := []map[string]interface{}{}
+	decoder := json.NewDecoder(lines)
+	for decoder.More() {
+		entry := map[string]interface{}{}
+		if err := decoder.Decode(&entry); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestLogRequestsPropagatesRequestID(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	store = NewMockStore(mockCtrl)
+	mockStore := store.(*MockStore)
+
+	mockStore.EXPECT().getPerson(gomock.Any(), 3).Return(Person{}, ErrNotFound).Times(1)
+
+	lines := captureLogs(t, slog.LevelInfo)
+
+	req, err := http.NewRequest("GET", "/people/3", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Request-ID", "abc-123")
+
+	rr := httptest.NewRecorder()
+
+	router := createRouter()
+	router.ServeHTTP(rr, req)
+
+	if id := rr.Header().Get("X-Request-ID"); id != "abc-123" {
+		t.Errorf("handler returned wrong request id: got %v want %v", id, "abc-123")
+	}
+
+	expectedBody := `{"type":"about:blank","title":"Not Found","status":404,"detail":"Person 3 does not exist","instance":"/people/3","requestId":"abc-123"}`
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+
+	entries := logLines(t, lines)
+	if len(entries) != 1 {
+		t.Fatalf("wrong number of log lines: got %v want %v", len(entries), 1)
+	}
+	access := entries[0]
+	if access["msg"] != "request" || access["requestId"] != "abc-123" || access["status"] != float64(404) || access["route"] != "/people/{id}" {
+		t.Errorf("wrong access log line: %v", access)
+	}
+}
+
+func TestLogRequestsReplacesInvalidRequestID(t *testing.T) {
+	req, err := http.NewRequest("GET", "/unknown", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Request-ID", "two\nlines")
+
+	rr := httptest.NewRecorder()
+
+	router := createRouter()
+	router.ServeHTTP(rr, req)
+
+	if id := rr.Header().Get("X-Request-ID"); id != "test-request-id" {
+		t.Errorf("handler returned wrong request id: got %v want %v", id, "test-request-id")
+	}
+}
+
+func TestStoreErrorsAreLoggedWithCause(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	store = NewMockStore(mockCtrl)
+	mockStore := store.(*MockStore)
+
+	mockStore.EXPECT().getPerson(gomock.Any(), 3).Return(Person{}, errors.New("connection refused")).Times(1)
+
+	lines := captureLogs(t, slog.LevelInfo)
+
+	req, err := http.NewRequest("GET", "/people/3", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := createRouter()
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	entries := logLines(t, lines)
+	if len(entries) != 2 {
+		t.Fatalf("wrong number of log lines: got %v want %v", len(entries), 2)
+	}
+	storeErr := entries[0]
+	if storeErr["level"] != "ERROR" || storeErr["msg"] != "Could not load person 3" || storeErr["error"] != "connection refused" || storeErr["requestId"] != "test-request-id" {
+		t.Errorf("wrong store error log line: %v", storeErr)
+	}
+}
@@ -1,45 +1,177 @@
 package main
 
 import (
-	"log"
 	"net/http"
 	"github.com/gorilla/mux"
 	"encoding/json"
 	"strconv"
-	_ "github.com/bmizerany/pq"
+	_ "github.com/lib/pq"
 	"os"
+	"mime"
+	"errors"
+	"io"
+	"fmt"
+	"context"
+	"net"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 type Person struct {
 	Id 		int 	`json:"id" gorm:"primary_key" gorm:"AUTO_INCREMENT"`
 	Name 	string 	`json:"name"`
 	PhoneNr string 	`json:"phoneNr"`
+	// Version is incremented on every update and sent as the ETag
+	Version int 	`json:"-" gorm:"not null;default:1"`
+	// Tenant owns the person, the stores set it from the request context
+	Tenant string 	`json:"-" gorm:"not null"`
+	// CreatedAt and UpdatedAt are kept by the stores, a delete or restore
+	// is an update. The database stores set both to the time of the
+	// migration for older people, memory and file data may have neither.
+	// Missing times are left out with omitzero, go.mod requires Go 1.24
+	// because older versions ignore it.
+	CreatedAt time.Time 	`json:"createdAt,omitzero"`
+	UpdatedAt time.Time 	`json:"updatedAt,omitzero"`
+	// DeletedAt is set while the person is in the trash, gorm leaves such
+	// rows out of every query not marked Unscoped
+	DeletedAt *time.Time 	`json:"deletedAt,omitempty"`
 }
 
 var store Store
 
 func main() {
-	//Uncomment to use memory store
-	//store = &MemoryStore{0, make(map[int]Person)}
+	level, err := parseLogLevel(getEnv("LOG_LEVEL", "info"))
+	if err != nil {
+		fatal("invalid LOG_LEVEL", err)
+	}
+	logger = newLogger(os.Stderr, level)
 
-	store = SetupDbStorage()
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		err := runMigrateCommand(getEnv("STORE_BACKEND", "postgres"), os.Args[2:], os.Stdout)
+		if err != nil {
+			fatal("migration failed", err)
+		}
+		return
+	}
+
+	shutdownTracing, err := setupTracing(context.Background())
+	if err != nil {
+		fatal("cannot set up tracing", err)
+	}
+
+	authenticator, err = setupAuthentication()
+	if err != nil {
+		fatal("cannot set up authentication", err)
+	}
+
+	backend := SetupStorage(getEnv("STORE_BACKEND", "postgres"))
+	registerPoolMetrics(backend)
+	store = newTracingStore(newMetricsStore(backend))
 
 	//Define routes and methods
 	router := createRouter()
-	log.Fatal(http.ListenAndServe(":8080", router))
+
+	timeouts, err := parseRouteTimeouts(router, getEnv("REQUEST_TIMEOUT", "30s"), getEnv("ROUTE_TIMEOUTS", ""))
+	if err != nil {
+		fatal("invalid request timeouts", err)
+	}
+	requestTimeouts = timeouts
+
+	quotas, err = parseTenantQuotas(getEnv("TENANT_QUOTA", "0"), getEnv("TENANT_QUOTAS", ""))
+	if err != nil {
+		fatal("invalid tenant quotas", err)
+	}
+
+	accessPolicy, err = loadPolicy(router, getEnv("POLICY_FILE", ""))
+	if err != nil {
+		fatal("invalid policy", err)
+	}
+
+	server := newServer(router)
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		fatal("cannot listen", err)
+	}
+	logger.Info("listening", "addr", listener.Addr().String())
+
+	// Kubernetes sends SIGTERM and waits for the grace period before killing
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Deleted people stay in the trash for the retention period
+	retention := getEnvDuration("PURGE_RETENTION", 30*24*time.Hour)
+	purgeInterval := getEnvDuration("PURGE_INTERVAL", time.Hour)
+	if retention <= 0 || purgeInterval <= 0 {
+		fatal("invalid purge settings", fmt.Errorf("PURGE_RETENTION and PURGE_INTERVAL must be positive"))
+	}
+	purgeStopped := startPurge(ctx, store, retention, purgeInterval)
+
+	err = serve(ctx, server, listener, getEnvDuration("SHUTDOWN_DELAY", 5*time.Second), getEnvDuration("SHUTDOWN_TIMEOUT", 20*time.Second))
+	stop()
+	<-purgeStopped
+	if closer, ok := store.(io.Closer); ok {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if flushErr := shutdownTracing(context.Background()); err == nil {
+		err = flushErr
+	}
+	if err != nil {
+		fatal("shutdown failed", err)
+	}
+	logger.Info("stopped")
 }
 
 func createRouter() *mux.Router{
 	router := mux.NewRouter()
-	router.HandleFunc("/people", GetPeople).Methods("GET")
-	router.HandleFunc("/people", CreatePerson).Methods("POST")
-	router.HandleFunc("/people/{id}", GetPerson).Methods("GET")
-	router.HandleFunc("/people/{id}", UpdatePerson).Methods("PUT")
-	router.HandleFunc("/people/{id}", DeletePerson).Methods("DELETE")
+	router.HandleFunc("/people", GetPeople).Methods("GET").Name("getPeople")
+	router.HandleFunc("/people", CreatePerson).Methods("POST").Name("createPerson")
+	router.HandleFunc("/people/search", SearchPeople).Methods("GET").Name("searchPeople")
+	router.HandleFunc("/people/{id}", GetPerson).Methods("GET").Name("getPerson")
+	router.HandleFunc("/people/{id}", UpdatePerson).Methods("PUT").Name("updatePerson")
+	router.HandleFunc("/people/{id}", PatchPerson).Methods("PATCH").Name("patchPerson")
+	router.HandleFunc("/people/{id}", DeletePerson).Methods("DELETE").Name("deletePerson")
+	router.HandleFunc("/people/{id}/restore", RestorePerson).Methods("POST").Name("restorePerson")
+	router.HandleFunc("/people/{id}/history", GetPersonHistory).Methods("GET").Name("getPersonHistory")
+	router.HandleFunc("/audit", GetAudit).Methods("GET").Name("getAudit")
+
+	router.HandleFunc("/healthz", Healthz).Methods("GET").Name("healthz")
+	router.HandleFunc("/readyz", Readyz).Methods("GET").Name("readyz")
+	router.Handle("/metrics", Metrics).Methods("GET").Name("metrics")
+
+	router.Use(traceRequests)
+	router.Use(logRequests)
+	router.Use(recordRequestMetrics)
+	router.Use(requireAuthentication)
+	router.Use(authorize)
+	router.Use(selectTenant)
+	router.Use(limitRequestTime)
+
+	// Middleware only runs for matched routes
+	router.NotFoundHandler = traceRequests(logRequests(http.HandlerFunc(notFoundHandler)))
+	router.MethodNotAllowedHandler = traceRequests(logRequests(http.HandlerFunc(methodNotAllowedHandler)))
 
 	return router
 }
 
+// SetupStorage opens the store of the given backend: postgres, sqlite,
+// file or memory
+func SetupStorage(backend string) Store {
+	switch backend {
+	case "postgres":
+		return SetupDbStorage()
+	case "sqlite":
+		return SetupSqliteStorage()
+	case "file":
+		return SetupFileStorage()
+	case "memory":
+		return NewMemoryStore()
+	}
+	panic("unknown STORE_BACKEND " + backend + ", use postgres, sqlite, file or memory")
+}
+
 func getEnv(name string, defaultVal string) string {
 	env := os.Getenv(name)
 	if len(env) == 0 {
@@ -49,13 +181,24 @@ func getEnv(name string, defaultVal string) string {
 }
 
 func GetPeople(w http.ResponseWriter, r *http.Request) {
-	people, err := store.getPeople()
+	query, fieldErrors := parsePeopleQuery(r.URL.Query())
+	page, pageErrors := parsePageRequest(r.URL.Query())
+	fieldErrors = append(fieldErrors, pageErrors...)
+	if len(fieldErrors) > 0 {
+		writeValidationErrors(w, r, http.StatusBadRequest, fieldErrors)
+		return
+	}
+
+	people, total, err := store.getPeople(r.Context(), page.storeQuery(query))
 
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		writeStoreError(w, r, err, "Could not load people")
 		return
 	}
 
+	people, links := page.trim(r, people, total)
+	writePageHeaders(w, links, total)
+
 	if len(people) == 0 {
 		w.WriteHeader(http.StatusNoContent)
 		return
@@ -64,37 +207,45 @@ func GetPeople(w http.ResponseWriter, r *http.Request) {
 	personListBytes, err := json.Marshal(people)
 
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, "Could not encode people")
 		return
 	}
 	w.Write(personListBytes)
 }
 
 func CreatePerson(w http.ResponseWriter, r *http.Request) {
-	person := Person{}
-
-	err := r.ParseForm()
-
+	person, err := decodePerson(r)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		writeInvalidBody(w, r, err)
 		return
 	}
 
-	person.Name = r.Form.Get("name")
-	person.PhoneNr = r.Form.Get("phoneNr")
+	// The id is always assigned by the store
+	person.Id = 0
 
-	if len(person.Name) == 0 || len(person.PhoneNr) == 0 {
-		w.WriteHeader(http.StatusBadRequest)
+	if fieldErrors := validatePerson(person); len(fieldErrors) > 0 {
+		writeValidationErrors(w, r, http.StatusBadRequest, fieldErrors)
 		return
 	}
 
-	err = store.createPerson(person)
+	person, err = store.createPerson(r.Context(), person)
+	if err != nil {
+		writeStoreError(w, r, err, "Could not create person")
+		return
+	}
+
+	personBytes, err := json.Marshal(person)
+
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, "Could not encode person")
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/people/"+strconv.Itoa(person.Id))
+	setValidators(w, person)
 	w.WriteHeader(http.StatusCreated)
+	w.Write(personBytes)
 }
 
 func GetPerson(w http.ResponseWriter, r *http.Request) {
@@ -103,20 +254,26 @@ func GetPerson(w http.ResponseWriter, r *http.Request) {
 
 	id, err := strconv.Atoi(pId)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeInvalidId(w, r, pId)
+		return
+	}
+
+	person, err := store.getPerson(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, r, err, "Could not load person "+pId)
 		return
 	}
 
-	person, err := store.getPerson(id)
-	if err != nil && err.Error() == "Person not found" {
-		w.WriteHeader(http.StatusNotFound)
+	setValidators(w, person)
+	if notModified(r, person) {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 
 	personBytes, err := json.Marshal(person)
 
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, "Could not encode person")
 		return
 	}
 
@@ -125,39 +282,41 @@ func GetPerson(w http.ResponseWriter, r *http.Request) {
 }
 
 func UpdatePerson(w http.ResponseWriter, r *http.Request) {
-	person := Person{}
-
-	err := r.ParseForm()
+	params := mux.Vars(r)
+	pId := params["id"]
 
+	id, err := strconv.Atoi(pId)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeInvalidId(w, r, pId)
 		return
 	}
 
-	params := mux.Vars(r)
-	pId := params["id"]
-
-	id, err := strconv.Atoi(pId)
+	person, err := decodePerson(r)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeInvalidBody(w, r, err)
 		return
 	}
 
 	person.Id = id
-	person.Name = r.Form.Get("name")
-	person.PhoneNr = r.Form.Get("phoneNr")
 
-	if len(person.Name) == 0 || len(person.PhoneNr) == 0 {
-		w.WriteHeader(http.StatusBadRequest)
+	if fieldErrors := validatePerson(person); len(fieldErrors) > 0 {
+		writeValidationErrors(w, r, http.StatusBadRequest, fieldErrors)
+		return
+	}
+
+	version, ok := checkIfMatch(w, r, id)
+	if !ok {
 		return
 	}
+	person.Version = version
 
-	err = store.updatePerson(person)
+	person, err = store.updatePerson(r.Context(), person)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		writeStoreError(w, r, err, "Could not update person "+pId)
 		return
 	}
 
+	setValidators(w, person)
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -168,15 +327,94 @@ func DeletePerson(w http.ResponseWriter, r *http.Request) {
 
 	id, err := strconv.Atoi(pId)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeInvalidId(w, r, pId)
+		return
+	}
+
+	version, ok := checkIfMatch(w, r, id)
+	if !ok {
 		return
 	}
 
-	err = store.deletePerson(id)
+	err = store.deletePerson(r.Context(), id, version)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		writeStoreError(w, r, err, "Could not delete person "+pId)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
-}
\ No newline at end of file
+}
+
+// RestorePerson takes a deleted person out of the trash
+func RestorePerson(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	pId := params["id"]
+
+	id, err := strconv.Atoi(pId)
+	if err != nil {
+		writeInvalidId(w, r, pId)
+		return
+	}
+
+	person, err := store.restorePerson(r.Context(), id, ifMatchVersion(r))
+	if err != nil {
+		writeStoreError(w, r, err, "Could not restore person "+pId)
+		return
+	}
+
+	personBytes, err := json.Marshal(person)
+
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Could not encode person")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	setValidators(w, person)
+	w.Write(personBytes)
+}
+
+// Maximum accepted size of a request body in bytes
+const maxBodySize = 1 << 20
+
+// decodePerson reads a person from the request body. JSON is used when the
+// Content-Type is application/json, everything else falls back to form encoding.
+func decodePerson(r *http.Request) (Person, error) {
+	person := Person{}
+
+	mediaType := ""
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		var err error
+		mediaType, _, err = mime.ParseMediaType(contentType)
+		if err != nil {
+			return person, fmt.Errorf("invalid Content-Type: %v", err)
+		}
+	}
+
+	if mediaType != "application/json" {
+		err := r.ParseForm()
+		if err != nil {
+			return person, fmt.Errorf("invalid form body: %v", err)
+		}
+		person.Name = r.Form.Get("name")
+		person.PhoneNr = r.Form.Get("phoneNr")
+		return person, nil
+	}
+
+	decoder := json.NewDecoder(io.LimitReader(r.Body, maxBodySize))
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(&person)
+	if err == io.EOF {
+		return person, errors.New("request body must not be empty")
+	}
+	if err != nil {
+		return person, fmt.Errorf("invalid JSON body: %v", err)
+	}
+
+	if decoder.Decode(&struct{}{}) != io.EOF {
+		return person, errors.New("request body must contain a single JSON object")
+	}
+
+	return person, nil
+}
@@ -1,15 +1,28 @@
 package main
 
 import (
+	"context"
 	"testing"
 	"net/http/httptest"
 	"github.com/golang/mock/gomock"
 	"net/http"
 	"errors"
+	"fmt"
 	"net/url"
 	"strings"
+	"time"
+	"os"
+	"io"
+	"log/slog"
 )
 
+func TestMain(m *testing.M) {
+	// A fixed request id keeps the expected problem documents stable
+	newRequestID = func() string { return "test-request-id" }
+	logger = newLogger(io.Discard, slog.LevelInfo)
+	os.Exit(m.Run())
+}
+
 func TestGetPeopleReturnsEmptyList(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
@@ -17,7 +30,7 @@ func TestGetPeopleReturnsEmptyList(t *testing.T) {
 	store = NewMockStore(mockCtrl)
 	mockStore := store.(*MockStore)
 
-	mockStore.EXPECT().getPeople().Return([]Person{}, nil).Times(1)
+	mockStore.EXPECT().getPeople(gomock.Any(), PeopleQuery{Limit:100}).Return([]Person{}, 0, nil).Times(1)
 
 	req, err := http.NewRequest("GET", "/people", nil)
 	if err != nil {
@@ -54,7 +67,7 @@ func TestGetPeopleReturnsList(t *testing.T) {
 	pList = append(pList, Person{Id:3, Name:"Peter", PhoneNr:"24525345626"})
 	pList = append(pList, Person{Id:4, Name:"Alice", PhoneNr:"12343463462345243"})
 
-	mockStore.EXPECT().getPeople().Return(pList, nil).Times(1)
+	mockStore.EXPECT().getPeople(gomock.Any(), PeopleQuery{Limit:100}).Return(pList, 3, nil).Times(1)
 
 	req, err := http.NewRequest("GET", "/people", nil)
 	if err != nil {
@@ -86,7 +99,7 @@ func TestGetPeopleReturnsError(t *testing.T) {
 	store = NewMockStore(mockCtrl)
 	mockStore := store.(*MockStore)
 
-	mockStore.EXPECT().getPeople().Return([]Person{}, errors.New("getPeopleError")).Times(1)
+	mockStore.EXPECT().getPeople(gomock.Any(), PeopleQuery{Limit:100}).Return([]Person{}, 0, errors.New("getPeopleError")).Times(1)
 
 	req, err := http.NewRequest("GET", "/people", nil)
 	if err != nil {
@@ -104,23 +117,23 @@ func TestGetPeopleReturnsError(t *testing.T) {
 			status, expectedStatus)
 	}
 
-	expectedBody := ``
+	expectedBody := `{"type":"about:blank","title":"Internal Server Error","status":500,"detail":"Could not load people","instance":"/people"}`
 	if rr.Body.String() != expectedBody {
 		t.Errorf("handler returned unexpected body: got %v want %v",
 			rr.Body.String(), expectedBody)
 	}
 }
 
-func TestGetPersonReturnsPerson(t *testing.T) {
+func TestGetPeopleReturnsServiceUnavailable(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
 
 	store = NewMockStore(mockCtrl)
 	mockStore := store.(*MockStore)
 
-	mockStore.EXPECT().getPerson(3).Return(Person{Id:3, Name:"Peter", PhoneNr:"24525345626"}, nil).Times(1)
+	mockStore.EXPECT().getPeople(gomock.Any(), PeopleQuery{Limit:100}).Return(nil, 0, fmt.Errorf("%w: connection refused", ErrUnavailable)).Times(1)
 
-	req, err := http.NewRequest("GET", "/people/3", nil)
+	req, err := http.NewRequest("GET", "/people", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -130,29 +143,33 @@ func TestGetPersonReturnsPerson(t *testing.T) {
 	router := createRouter()
 	router.ServeHTTP(rr, req)
 
-	expectedStatus := http.StatusOK
+	expectedStatus := http.StatusServiceUnavailable
 	if status := rr.Code; status != expectedStatus {
 		t.Errorf("handler returned wrong status code: got %v want %v",
 			status, expectedStatus)
 	}
 
-	expectedBody := `{"id":3,"name":"Peter","phoneNr":"24525345626"}`
+	expectedBody := `{"type":"about:blank","title":"Service Unavailable","status":503,"detail":"The store is currently unavailable, try again later","instance":"/people","requestId":"test-request-id"}`
 	if rr.Body.String() != expectedBody {
 		t.Errorf("handler returned unexpected body: got %v want %v",
 			rr.Body.String(), expectedBody)
 	}
 }
 
-func TestGetPersonReturnsNotFound(t *testing.T) {
+func TestGetPeopleReturnsPageWithOffset(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
 
 	store = NewMockStore(mockCtrl)
 	mockStore := store.(*MockStore)
 
-	mockStore.EXPECT().getPerson(300).Return(Person{}, errors.New("Person not found")).Times(1)
+	pList := []Person{}
+	pList = append(pList, Person{Id:3, Name:"Peter", PhoneNr:"24525345626"})
+	pList = append(pList, Person{Id:4, Name:"Alice", PhoneNr:"12343463462345243"})
+
+	mockStore.EXPECT().getPeople(gomock.Any(), PeopleQuery{Limit:2, Offset:2}).Return(pList, 7, nil).Times(1)
 
-	req, err := http.NewRequest("GET", "/people/300", nil)
+	req, err := http.NewRequest("GET", "/people?limit=2&offset=2", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -162,21 +179,47 @@ func TestGetPersonReturnsNotFound(t *testing.T) {
 	router := createRouter()
 	router.ServeHTTP(rr, req)
 
-	expectedStatus := http.StatusNotFound
+	expectedStatus := http.StatusOK
 	if status := rr.Code; status != expectedStatus {
 		t.Errorf("handler returned wrong status code: got %v want %v",
 			status, expectedStatus)
 	}
 
-	expectedBody := ``
+	expectedTotal := "7"
+	if total := rr.Header().Get("X-Total-Count"); total != expectedTotal {
+		t.Errorf("handler returned wrong total count: got %v want %v",
+			total, expectedTotal)
+	}
+
+	expectedLinks := []string{`</people?limit=2&offset=0>; rel="prev"`, `</people?limit=2&offset=4>; rel="next"`}
+	if links := rr.Header()["Link"]; strings.Join(links, ",") != strings.Join(expectedLinks, ",") {
+		t.Errorf("handler returned wrong links: got %v want %v",
+			links, expectedLinks)
+	}
+
+	expectedBody := `[{"id":3,"name":"Peter","phoneNr":"24525345626"},{"id":4,"name":"Alice","phoneNr":"12343463462345243"}]`
 	if rr.Body.String() != expectedBody {
 		t.Errorf("handler returned unexpected body: got %v want %v",
 			rr.Body.String(), expectedBody)
 	}
 }
 
-func TestGetPersonReturnsBadRequest(t *testing.T) {
-	req, err := http.NewRequest("GET", "/people/a", nil)
+func TestGetPeopleReturnsPageWithCursor(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	store = NewMockStore(mockCtrl)
+	mockStore := store.(*MockStore)
+
+	pList := []Person{}
+	pList = append(pList, Person{Id:3, Name:"Peter", PhoneNr:"24525345626"})
+	pList = append(pList, Person{Id:4, Name:"Alice", PhoneNr:"12343463462345243"})
+	pList = append(pList, Person{Id:6, Name:"Paul", PhoneNr:"643265776357948984"})
+
+	mockStore.EXPECT().getPeople(gomock.Any(), PeopleQuery{Limit:3, After:&Person{Id:1}}).Return(pList, 7, nil).Times(1)
+
+	cursor := encodeCursor(pageCursor{After: &Person{Id:1}})
+	req, err := http.NewRequest("GET", "/people?limit=2&cursor="+cursor, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -186,26 +229,30 @@ func TestGetPersonReturnsBadRequest(t *testing.T) {
 	router := createRouter()
 	router.ServeHTTP(rr, req)
 
-	expectedStatus := http.StatusBadRequest
+	expectedStatus := http.StatusOK
 	if status := rr.Code; status != expectedStatus {
 		t.Errorf("handler returned wrong status code: got %v want %v",
 			status, expectedStatus)
 	}
 
-	expectedBody := ``
+	expectedLinks := []string{
+		`</people?cursor=` + encodeCursor(pageCursor{Before: &pList[0]}) + `&limit=2>; rel="prev"`,
+		`</people?cursor=` + encodeCursor(pageCursor{After: &pList[1]}) + `&limit=2>; rel="next"`,
+	}
+	if links := rr.Header()["Link"]; strings.Join(links, ",") != strings.Join(expectedLinks, ",") {
+		t.Errorf("handler returned wrong links: got %v want %v",
+			links, expectedLinks)
+	}
+
+	expectedBody := `[{"id":3,"name":"Peter","phoneNr":"24525345626"},{"id":4,"name":"Alice","phoneNr":"12343463462345243"}]`
 	if rr.Body.String() != expectedBody {
 		t.Errorf("handler returned unexpected body: got %v want %v",
 			rr.Body.String(), expectedBody)
 	}
 }
 
-func TestCreatePersonReturnsErrorBadRequest(t *testing.T) {
-	v := url.Values{}
-	v.Set("name", "Peter")
-
-	req, err := http.NewRequest("POST", "/people", strings.NewReader(v.Encode()))
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
+func TestGetPeopleReturnsBadRequestForInvalidPage(t *testing.T) {
+	req, err := http.NewRequest("GET", "/people?limit=0&offset=2&cursor=abc", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -221,30 +268,57 @@ func TestCreatePersonReturnsErrorBadRequest(t *testing.T) {
 			status, expectedStatus)
 	}
 
-	expectedBody := ``
+	expectedBody := `{"type":"/problems/validation-error","title":"Validation failed","status":400,"detail":"One or more fields are invalid","instance":"/people","errors":[{"field":"limit","message":"must be an integer between 1 and 1000"},{"field":"cursor","message":"cannot be combined with offset"},{"field":"cursor","message":"is not a valid cursor"}],"requestId":"test-request-id"}`
 	if rr.Body.String() != expectedBody {
 		t.Errorf("handler returned unexpected body: got %v want %v",
 			rr.Body.String(), expectedBody)
 	}
 }
 
-func TestCreatePersonReturnsError(t *testing.T) {
+func TestGetPeopleReturnsFilteredAndSortedList(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
 
 	store = NewMockStore(mockCtrl)
 	mockStore := store.(*MockStore)
 
-	p := Person{Name:"Peter", PhoneNr:"56468465613275"}
-	mockStore.EXPECT().createPerson(p).Return(errors.New("createPersonError")).Times(1)
+	pList := []Person{}
+	pList = append(pList, Person{Id:3, Name:"Peter", PhoneNr:"24525345626"})
+	pList = append(pList, Person{Id:1, Name:"Paul", PhoneNr:"243265776357948984"})
 
-	v := url.Values{}
-	v.Set("name", "Peter")
-	v.Add("phoneNr", "56468465613275")
+	query := PeopleQuery{
+		NamePrefix:    "P",
+		PhoneNrPrefix: "24",
+		Sort:          []SortField{{Field:"name", Descending:true}, {Field:"id"}},
+		Limit:         100,
+	}
+	mockStore.EXPECT().getPeople(gomock.Any(), query).Return(pList, 2, nil).Times(1)
 
-	req, err := http.NewRequest("POST", "/people", strings.NewReader(v.Encode()))
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req, err := http.NewRequest("GET", "/people?name_prefix=P&phoneNr_prefix=24&sort=-name,id", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	router := createRouter()
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusOK
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
 
+	expectedBody := `[{"id":3,"name":"Peter","phoneNr":"24525345626"},{"id":1,"name":"Paul","phoneNr":"243265776357948984"}]`
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+}
+
+func TestGetPeopleReturnsBadRequestForInvalidSort(t *testing.T) {
+	req, err := http.NewRequest("GET", "/people?sort=name,-email,-name", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -254,76 +328,93 @@ func TestCreatePersonReturnsError(t *testing.T) {
 	router := createRouter()
 	router.ServeHTTP(rr, req)
 
-	expectedStatus := http.StatusInternalServerError
+	expectedStatus := http.StatusBadRequest
 	if status := rr.Code; status != expectedStatus {
 		t.Errorf("handler returned wrong status code: got %v want %v",
 			status, expectedStatus)
 	}
 
-	expectedBody := ``
+	expectedBody := `{"type":"/problems/validation-error","title":"Validation failed","status":400,"detail":"One or more fields are invalid","instance":"/people","errors":[{"field":"sort","message":"cannot sort by \"-email\""},{"field":"sort","message":"contains \"name\" more than once"}],"requestId":"test-request-id"}`
 	if rr.Body.String() != expectedBody {
 		t.Errorf("handler returned unexpected body: got %v want %v",
 			rr.Body.String(), expectedBody)
 	}
 }
 
-func TestCreatePersonReturnsOk(t *testing.T) {
+func TestGetPeopleReturnsPeopleUpdatedSince(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
 
 	store = NewMockStore(mockCtrl)
 	mockStore := store.(*MockStore)
 
-	p := Person{Name:"Peter", PhoneNr:"56468465613275"}
-	mockStore.EXPECT().createPerson(p).Return(nil).Times(1)
+	updated := time.Date(2024, 1, 31, 8, 30, 0, 0, time.UTC)
+	pList := []Person{
+		{Id:2, Name:"Paul", PhoneNr:"643265776357948984", CreatedAt:updated, UpdatedAt:updated},
+	}
+	mockStore.EXPECT().getPeople(gomock.Any(), PeopleQuery{UpdatedSince:time.Date(2024, 1, 31, 8, 0, 0, 0, time.UTC), Limit:100}).Return(pList, 1, nil).Times(1)
 
-	v := url.Values{}
-	v.Set("name", "Peter")
-	v.Add("phoneNr", "56468465613275")
+	req, err := http.NewRequest("GET", "/people?updated_since=2024-01-31T09:00:00%2B01:00", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	req, err := http.NewRequest("POST", "/people", strings.NewReader(v.Encode()))
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	router := createRouter()
+
+	router.ServeHTTP(rr, req)
 
+	expectedStatus := http.StatusOK
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+
+	expectedBody := `[{"id":2,"name":"Paul","phoneNr":"643265776357948984","createdAt":"2024-01-31T08:30:00Z","updatedAt":"2024-01-31T08:30:00Z"}]`
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+}
+
+func TestGetPeopleReturnsBadRequestForInvalidUpdatedSince(t *testing.T) {
+	req, err := http.NewRequest("GET", "/people?updated_since=yesterday", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	rr := httptest.NewRecorder()
-
 	router := createRouter()
+
 	router.ServeHTTP(rr, req)
 
-	expectedStatus := http.StatusCreated
+	expectedStatus := http.StatusBadRequest
 	if status := rr.Code; status != expectedStatus {
 		t.Errorf("handler returned wrong status code: got %v want %v",
 			status, expectedStatus)
 	}
 
-	expectedBody := ``
+	expectedBody := `{"type":"/problems/validation-error","title":"Validation failed","status":400,"detail":"One or more fields are invalid","instance":"/people","errors":[{"field":"updated_since","message":"must be an RFC 3339 timestamp like 2024-01-31T08:00:00Z"}],"requestId":"test-request-id"}`
 	if rr.Body.String() != expectedBody {
 		t.Errorf("handler returned unexpected body: got %v want %v",
 			rr.Body.String(), expectedBody)
 	}
 }
 
-func TestUpdatePersonReturnsError(t *testing.T) {
+func TestSearchPeopleReturnsResults(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
 
 	store = NewMockStore(mockCtrl)
 	mockStore := store.(*MockStore)
 
-	p := Person{Id:1, Name:"Peter", PhoneNr:"56468465613275"}
-	mockStore.EXPECT().updatePerson(p).Return(errors.New("Error in updatePerson")).Times(1)
-
-	v := url.Values{}
-	v.Set("name", "Peter")
-	v.Add("phoneNr", "56468465613275")
-	v.Add("id", "1")
+	results := []SearchResult{}
+	results = append(results, SearchResult{Person{Id:3, Name:"Peter", PhoneNr:"24525345626"}, 0.5})
+	results = append(results, SearchResult{Person{Id:5, Name:"Petra", PhoneNr:"12343463462345243"}, 0.375})
 
-	req, err := http.NewRequest("PUT", "/people/1", strings.NewReader(v.Encode()))
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	mockStore.EXPECT().searchPeople(gomock.Any(), "Petr", 20).Return(results, nil).Times(1)
 
+	req, err := http.NewRequest("GET", "/people/search?q=Petr", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -333,26 +424,21 @@ func TestUpdatePersonReturnsError(t *testing.T) {
 	router := createRouter()
 	router.ServeHTTP(rr, req)
 
-	expectedStatus := http.StatusInternalServerError
+	expectedStatus := http.StatusOK
 	if status := rr.Code; status != expectedStatus {
 		t.Errorf("handler returned wrong status code: got %v want %v",
 			status, expectedStatus)
 	}
 
-	expectedBody := ``
+	expectedBody := `[{"id":3,"name":"Peter","phoneNr":"24525345626","score":0.5},{"id":5,"name":"Petra","phoneNr":"12343463462345243","score":0.375}]`
 	if rr.Body.String() != expectedBody {
 		t.Errorf("handler returned unexpected body: got %v want %v",
 			rr.Body.String(), expectedBody)
 	}
 }
 
-func TestUpdatePersonReturnsErrorIncompletePerson(t *testing.T) {
-	v := url.Values{}
-	v.Set("name", "Peter")
-
-	req, err := http.NewRequest("PUT", "/people/1", strings.NewReader(v.Encode()))
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
+func TestSearchPeopleReturnsBadRequest(t *testing.T) {
+	req, err := http.NewRequest("GET", "/people/search?q=%20&limit=500", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -368,20 +454,23 @@ func TestUpdatePersonReturnsErrorIncompletePerson(t *testing.T) {
 			status, expectedStatus)
 	}
 
-	expectedBody := ``
+	expectedBody := `{"type":"/problems/validation-error","title":"Validation failed","status":400,"detail":"One or more fields are invalid","instance":"/people/search","errors":[{"field":"q","message":"must not be empty"},{"field":"limit","message":"must be an integer between 1 and 100"}],"requestId":"test-request-id"}`
 	if rr.Body.String() != expectedBody {
 		t.Errorf("handler returned unexpected body: got %v want %v",
 			rr.Body.String(), expectedBody)
 	}
 }
 
-func TestUpdatePersonReturnsErrorMissingId(t *testing.T) {
-	v := url.Values{}
-	v.Set("name", "Peter")
+func TestGetPersonReturnsPerson(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
 
-	req, err := http.NewRequest("PUT", "/people", strings.NewReader(v.Encode()))
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	store = NewMockStore(mockCtrl)
+	mockStore := store.(*MockStore)
+
+	mockStore.EXPECT().getPerson(gomock.Any(), 3).Return(Person{Id:3, Name:"Peter", PhoneNr:"24525345626"}, nil).Times(1)
 
+	req, err := http.NewRequest("GET", "/people/3", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -391,26 +480,29 @@ func TestUpdatePersonReturnsErrorMissingId(t *testing.T) {
 	router := createRouter()
 	router.ServeHTTP(rr, req)
 
-	expectedStatus := http.StatusMethodNotAllowed
+	expectedStatus := http.StatusOK
 	if status := rr.Code; status != expectedStatus {
 		t.Errorf("handler returned wrong status code: got %v want %v",
 			status, expectedStatus)
 	}
 
-	expectedBody := ``
+	expectedBody := `{"id":3,"name":"Peter","phoneNr":"24525345626"}`
 	if rr.Body.String() != expectedBody {
 		t.Errorf("handler returned unexpected body: got %v want %v",
 			rr.Body.String(), expectedBody)
 	}
 }
 
-func TestUpdatePersonReturnsErrorBadId(t *testing.T) {
-	v := url.Values{}
-	v.Set("name", "Peter")
+func TestGetPersonReturnsNotModified(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
 
-	req, err := http.NewRequest("PUT", "/people/a", strings.NewReader(v.Encode()))
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	store = NewMockStore(mockCtrl)
+	mockStore := store.(*MockStore)
 
+	mockStore.EXPECT().getPerson(gomock.Any(), 3).Return(Person{Id:3, Name:"Peter", PhoneNr:"24525345626", Version:2}, nil).Times(2)
+
+	req, err := http.NewRequest("GET", "/people/3", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -420,7 +512,17 @@ func TestUpdatePersonReturnsErrorBadId(t *testing.T) {
 	router := createRouter()
 	router.ServeHTTP(rr, req)
 
-	expectedStatus := http.StatusBadRequest
+	expectedETag := `"2"`
+	if eTag := rr.Header().Get("ETag"); eTag != expectedETag {
+		t.Errorf("handler returned wrong ETag: got %v want %v",
+			eTag, expectedETag)
+	}
+
+	req.Header.Set("If-None-Match", `W/"1", W/"2"`)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusNotModified
 	if status := rr.Code; status != expectedStatus {
 		t.Errorf("handler returned wrong status code: got %v want %v",
 			status, expectedStatus)
@@ -433,24 +535,71 @@ func TestUpdatePersonReturnsErrorBadId(t *testing.T) {
 	}
 }
 
-func TestUpdatePersonReturnsOk(t *testing.T) {
+func TestGetPersonReturnsNotModifiedSince(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
 
 	store = NewMockStore(mockCtrl)
 	mockStore := store.(*MockStore)
 
-	p := Person{Id:1, Name:"Peter", PhoneNr:"56468465613275"}
-	mockStore.EXPECT().updatePerson(p).Return(nil).Times(1)
+	created := time.Date(2024, 1, 30, 12, 0, 0, 0, time.UTC)
+	updated := time.Date(2024, 1, 31, 8, 0, 0, 500000000, time.UTC)
+	mockStore.EXPECT().getPerson(gomock.Any(), 3).Return(Person{Id:3, Name:"Peter", PhoneNr:"24525345626", Version:2, CreatedAt:created, UpdatedAt:updated}, nil).Times(4)
 
-	v := url.Values{}
-	v.Set("name", "Peter")
-	v.Add("phoneNr", "56468465613275")
-	v.Add("id", "1")
+	req, err := http.NewRequest("GET", "/people/3", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	req, err := http.NewRequest("PUT", "/people/1", strings.NewReader(v.Encode()))
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+
+	router := createRouter()
+	router.ServeHTTP(rr, req)
+
+	expectedLastModified := "Wed, 31 Jan 2024 08:00:00 GMT"
+	if lastModified := rr.Header().Get("Last-Modified"); lastModified != expectedLastModified {
+		t.Errorf("handler returned wrong Last-Modified: got %v want %v",
+			lastModified, expectedLastModified)
+	}
+
+	expectedBody := `{"id":3,"name":"Peter","phoneNr":"24525345626","createdAt":"2024-01-30T12:00:00Z","updatedAt":"2024-01-31T08:00:00.5Z"}`
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+
+	for _, tc := range []struct {
+		ifModifiedSince string
+		ifNoneMatch     string
+		expectedStatus  int
+	}{
+		{expectedLastModified, "", http.StatusNotModified},
+		{"Wed, 31 Jan 2024 07:59:59 GMT", "", http.StatusOK},
+		// If-None-Match takes precedence
+		{expectedLastModified, `"1"`, http.StatusOK},
+	} {
+		req.Header.Set("If-Modified-Since", tc.ifModifiedSince)
+		req.Header.Set("If-None-Match", tc.ifNoneMatch)
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != tc.expectedStatus {
+			t.Errorf("handler returned wrong status code for %q and %q: got %v want %v",
+				tc.ifModifiedSince, tc.ifNoneMatch, status, tc.expectedStatus)
+		}
+	}
+}
+
+func TestGetPersonReturnsNotFound(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	store = NewMockStore(mockCtrl)
+	mockStore := store.(*MockStore)
 
+	mockStore.EXPECT().getPerson(gomock.Any(), 300).Return(Person{}, ErrNotFound).Times(1)
+
+	req, err := http.NewRequest("GET", "/people/300", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -460,22 +609,35 @@ func TestUpdatePersonReturnsOk(t *testing.T) {
 	router := createRouter()
 	router.ServeHTTP(rr, req)
 
-	expectedStatus := http.StatusOK
+	expectedStatus := http.StatusNotFound
 	if status := rr.Code; status != expectedStatus {
 		t.Errorf("handler returned wrong status code: got %v want %v",
 			status, expectedStatus)
 	}
 
-	expectedBody := ``
+	expectedContentType := "application/problem+json"
+	if contentType := rr.Header().Get("Content-Type"); contentType != expectedContentType {
+		t.Errorf("handler returned wrong content type: got %v want %v",
+			contentType, expectedContentType)
+	}
+
+	expectedBody := `{"type":"about:blank","title":"Not Found","status":404,"detail":"Person 300 does not exist","instance":"/people/300","requestId":"test-request-id"}`
 	if rr.Body.String() != expectedBody {
 		t.Errorf("handler returned unexpected body: got %v want %v",
 			rr.Body.String(), expectedBody)
 	}
 }
 
-func TestDeletePersonReturnsBadRequest(t *testing.T) {
-	req, err := http.NewRequest("DELETE", "/people/a", nil)
+func TestGetPersonReturnsInternalServerError(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	store = NewMockStore(mockCtrl)
+	mockStore := store.(*MockStore)
 
+	mockStore.EXPECT().getPerson(gomock.Any(), 3).Return(Person{}, errors.New("connection refused")).Times(1)
+
+	req, err := http.NewRequest("GET", "/people/3", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -485,30 +647,37 @@ func TestDeletePersonReturnsBadRequest(t *testing.T) {
 	router := createRouter()
 	router.ServeHTTP(rr, req)
 
-	expectedStatus := http.StatusBadRequest
+	expectedStatus := http.StatusInternalServerError
 	if status := rr.Code; status != expectedStatus {
 		t.Errorf("handler returned wrong status code: got %v want %v",
 			status, expectedStatus)
 	}
 
-	expectedBody := ``
+	expectedBody := `{"type":"about:blank","title":"Internal Server Error","status":500,"detail":"Could not load person 3","instance":"/people/3","requestId":"test-request-id"}`
 	if rr.Body.String() != expectedBody {
 		t.Errorf("handler returned unexpected body: got %v want %v",
 			rr.Body.String(), expectedBody)
 	}
 }
 
-func TestDeletePersonReturnsInternalServerError(t *testing.T) {
+func TestGetPersonReturnsGatewayTimeout(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
 
 	store = NewMockStore(mockCtrl)
 	mockStore := store.(*MockStore)
 
-	mockStore.EXPECT().deletePerson(1).Return(errors.New("Error in deletePerson")).Times(1)
+	defaultTimeouts := requestTimeouts
+	defer func() { requestTimeouts = defaultTimeouts }()
+	requestTimeouts = routeTimeouts{Default:time.Minute, Routes:map[string]time.Duration{"getPerson":10 * time.Millisecond}}
 
-	req, err := http.NewRequest("DELETE", "/people/1", nil)
+	// The store only returns once the deadline of the route fired
+	mockStore.EXPECT().getPerson(gomock.Any(), 3).DoAndReturn(func(ctx context.Context, id int) (Person, error) {
+		<-ctx.Done()
+		return Person{}, ctx.Err()
+	}).Times(1)
 
+	req, err := http.NewRequest("GET", "/people/3", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -518,30 +687,53 @@ func TestDeletePersonReturnsInternalServerError(t *testing.T) {
 	router := createRouter()
 	router.ServeHTTP(rr, req)
 
-	expectedStatus := http.StatusInternalServerError
+	expectedStatus := http.StatusGatewayTimeout
 	if status := rr.Code; status != expectedStatus {
 		t.Errorf("handler returned wrong status code: got %v want %v",
 			status, expectedStatus)
 	}
 
-	expectedBody := ``
+	expectedBody := `{"type":"about:blank","title":"Gateway Timeout","status":504,"detail":"The store did not answer in time","instance":"/people/3","requestId":"test-request-id"}`
 	if rr.Body.String() != expectedBody {
 		t.Errorf("handler returned unexpected body: got %v want %v",
 			rr.Body.String(), expectedBody)
 	}
 }
 
-func TestDeletePersonReturnsOk(t *testing.T) {
+func TestGetPersonReturnsServiceUnavailableWhenCanceled(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
 
 	store = NewMockStore(mockCtrl)
 	mockStore := store.(*MockStore)
 
-	mockStore.EXPECT().deletePerson(1).Return(nil).Times(1)
+	mockStore.EXPECT().getPerson(gomock.Any(), 3).Return(Person{}, context.Canceled).Times(1)
 
-	req, err := http.NewRequest("DELETE", "/people/1", nil)
+	req, err := http.NewRequest("GET", "/people/3", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	router := createRouter()
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusServiceUnavailable
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+
+	expectedBody := `{"type":"about:blank","title":"Service Unavailable","status":503,"detail":"The request was canceled before the store answered","instance":"/people/3","requestId":"test-request-id"}`
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+}
 
+func TestGetPersonReturnsBadRequest(t *testing.T) {
+	req, err := http.NewRequest("GET", "/people/a", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -551,15 +743,1218 @@ func TestDeletePersonReturnsOk(t *testing.T) {
 	router := createRouter()
 	router.ServeHTTP(rr, req)
 
-	expectedStatus := http.StatusOK
+	expectedStatus := http.StatusBadRequest
 	if status := rr.Code; status != expectedStatus {
 		t.Errorf("handler returned wrong status code: got %v want %v",
 			status, expectedStatus)
 	}
 
-	expectedBody := ``
+	expectedBody := `{"type":"/problems/invalid-id","title":"Invalid person id","status":400,"detail":"id must be an integer, got \"a\"","instance":"/people/a","requestId":"test-request-id"}`
 	if rr.Body.String() != expectedBody {
 		t.Errorf("handler returned unexpected body: got %v want %v",
 			rr.Body.String(), expectedBody)
 	}
-}
\ No newline at end of file
+}
+
+func TestCreatePersonReturnsErrorBadRequest(t *testing.T) {
+	v := url.Values{}
+	v.Set("name", "Peter")
+
+	req, err := http.NewRequest("POST", "/people", strings.NewReader(v.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	router := createRouter()
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusBadRequest
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+
+	expectedContentType := "application/problem+json"
+	if contentType := rr.Header().Get("Content-Type"); contentType != expectedContentType {
+		t.Errorf("handler returned wrong content type: got %v want %v",
+			contentType, expectedContentType)
+	}
+
+	expectedBody := `{"type":"/problems/validation-error","title":"Validation failed","status":400,"detail":"One or more fields are invalid","instance":"/people","errors":[{"field":"phoneNr","message":"must not be empty"}],"requestId":"test-request-id"}`
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+}
+
+func TestCreatePersonReturnsError(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	store = NewMockStore(mockCtrl)
+	mockStore := store.(*MockStore)
+
+	p := Person{Name:"Peter", PhoneNr:"56468465613275"}
+	mockStore.EXPECT().createPerson(gomock.Any(), p).Return(Person{}, errors.New("createPersonError")).Times(1)
+
+	v := url.Values{}
+	v.Set("name", "Peter")
+	v.Add("phoneNr", "56468465613275")
+
+	req, err := http.NewRequest("POST", "/people", strings.NewReader(v.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	router := createRouter()
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusInternalServerError
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+
+	expectedBody := `{"type":"about:blank","title":"Internal Server Error","status":500,"detail":"Could not create person","instance":"/people","requestId":"test-request-id"}`
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+}
+
+func TestCreatePersonReturnsConflict(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	store = NewMockStore(mockCtrl)
+	mockStore := store.(*MockStore)
+
+	p := Person{Name:"Peter", PhoneNr:"56468465613275"}
+	mockStore.EXPECT().createPerson(gomock.Any(), p).Return(Person{}, fmt.Errorf("%w: duplicate key", ErrConflict)).Times(1)
+
+	v := url.Values{}
+	v.Set("name", "Peter")
+	v.Add("phoneNr", "56468465613275")
+
+	req, err := http.NewRequest("POST", "/people", strings.NewReader(v.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	router := createRouter()
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusConflict
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+
+	expectedBody := `{"type":"about:blank","title":"Conflict","status":409,"detail":"Person conflicts with existing data","instance":"/people","requestId":"test-request-id"}`
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+}
+
+func TestCreatePersonReturnsUnprocessableEntity(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	store = NewMockStore(mockCtrl)
+	mockStore := store.(*MockStore)
+
+	p := Person{Name:"Peter", PhoneNr:"56468465613275"}
+	validationErr := &ValidationError{[]FieldError{{"phoneNr", "is too long"}}}
+	mockStore.EXPECT().createPerson(gomock.Any(), p).Return(Person{}, validationErr).Times(1)
+
+	v := url.Values{}
+	v.Set("name", "Peter")
+	v.Add("phoneNr", "56468465613275")
+
+	req, err := http.NewRequest("POST", "/people", strings.NewReader(v.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	router := createRouter()
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusUnprocessableEntity
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+
+	expectedBody := `{"type":"/problems/validation-error","title":"Validation failed","status":422,"detail":"One or more fields are invalid","instance":"/people","errors":[{"field":"phoneNr","message":"is too long"}],"requestId":"test-request-id"}`
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+}
+
+func TestCreatePersonReturnsOk(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	store = NewMockStore(mockCtrl)
+	mockStore := store.(*MockStore)
+
+	p := Person{Name:"Peter", PhoneNr:"56468465613275"}
+	mockStore.EXPECT().createPerson(gomock.Any(), p).Return(Person{Id:5, Name:"Peter", PhoneNr:"56468465613275"}, nil).Times(1)
+
+	v := url.Values{}
+	v.Set("name", "Peter")
+	v.Add("phoneNr", "56468465613275")
+
+	req, err := http.NewRequest("POST", "/people", strings.NewReader(v.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	router := createRouter()
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusCreated
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+
+	expectedLocation := "/people/5"
+	if location := rr.Header().Get("Location"); location != expectedLocation {
+		t.Errorf("handler returned wrong location: got %v want %v",
+			location, expectedLocation)
+	}
+
+	expectedBody := `{"id":5,"name":"Peter","phoneNr":"56468465613275"}`
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+}
+
+func TestCreatePersonJsonReturnsOk(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	store = NewMockStore(mockCtrl)
+	mockStore := store.(*MockStore)
+
+	p := Person{Name:"Peter", PhoneNr:"56468465613275"}
+	mockStore.EXPECT().createPerson(gomock.Any(), p).Return(Person{Id:5, Name:"Peter", PhoneNr:"56468465613275"}, nil).Times(1)
+
+	body := `{"name":"Peter","phoneNr":"56468465613275"}`
+
+	req, err := http.NewRequest("POST", "/people", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	router := createRouter()
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusCreated
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+
+	expectedLocation := "/people/5"
+	if location := rr.Header().Get("Location"); location != expectedLocation {
+		t.Errorf("handler returned wrong location: got %v want %v",
+			location, expectedLocation)
+	}
+
+	expectedBody := `{"id":5,"name":"Peter","phoneNr":"56468465613275"}`
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+}
+
+func TestCreatePersonJsonReturnsErrorBadRequest(t *testing.T) {
+	body := `{"name":"Peter"}`
+
+	req, err := http.NewRequest("POST", "/people", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	router := createRouter()
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusBadRequest
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+
+	expectedBody := `{"type":"/problems/validation-error","title":"Validation failed","status":400,"detail":"One or more fields are invalid","instance":"/people","errors":[{"field":"phoneNr","message":"must not be empty"}],"requestId":"test-request-id"}`
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+}
+
+func TestCreatePersonJsonReturnsErrorUnknownField(t *testing.T) {
+	body := `{"name":"Peter","phoneNr":"56468465613275","email":"peter@example.com"}`
+
+	req, err := http.NewRequest("POST", "/people", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	router := createRouter()
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusBadRequest
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+
+	expectedBody := `{"type":"/problems/invalid-body","title":"Invalid request body","status":400,"detail":"invalid JSON body: json: unknown field \"email\"","instance":"/people","requestId":"test-request-id"}`
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+}
+
+func TestCreatePersonJsonReturnsErrorMalformed(t *testing.T) {
+	body := `{"name":"Peter","phoneNr":`
+
+	req, err := http.NewRequest("POST", "/people", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	router := createRouter()
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusBadRequest
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+
+	expectedBody := `{"type":"/problems/invalid-body","title":"Invalid request body","status":400,"detail":"invalid JSON body: unexpected EOF","instance":"/people","requestId":"test-request-id"}`
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+}
+
+func TestCreatePersonJsonReturnsErrorTrailingData(t *testing.T) {
+	body := `{"name":"Peter","phoneNr":"56468465613275"}{}`
+
+	req, err := http.NewRequest("POST", "/people", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	router := createRouter()
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusBadRequest
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+}
+
+func TestUpdatePersonReturnsError(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	store = NewMockStore(mockCtrl)
+	mockStore := store.(*MockStore)
+
+	p := Person{Id:1, Name:"Peter", PhoneNr:"56468465613275"}
+	mockStore.EXPECT().updatePerson(gomock.Any(), p).Return(Person{}, errors.New("Error in updatePerson")).Times(1)
+
+	v := url.Values{}
+	v.Set("name", "Peter")
+	v.Add("phoneNr", "56468465613275")
+	v.Add("id", "1")
+
+	req, err := http.NewRequest("PUT", "/people/1", strings.NewReader(v.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	router := createRouter()
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusInternalServerError
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+
+	expectedBody := `{"type":"about:blank","title":"Internal Server Error","status":500,"detail":"Could not update person 1","instance":"/people/1","requestId":"test-request-id"}`
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+}
+
+func TestUpdatePersonReturnsNotFound(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	store = NewMockStore(mockCtrl)
+	mockStore := store.(*MockStore)
+
+	p := Person{Id:300, Name:"Peter", PhoneNr:"56468465613275"}
+	mockStore.EXPECT().updatePerson(gomock.Any(), p).Return(Person{}, ErrNotFound).Times(1)
+
+	v := url.Values{}
+	v.Set("name", "Peter")
+	v.Add("phoneNr", "56468465613275")
+
+	req, err := http.NewRequest("PUT", "/people/300", strings.NewReader(v.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	router := createRouter()
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusNotFound
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+
+	expectedBody := `{"type":"about:blank","title":"Not Found","status":404,"detail":"Person 300 does not exist","instance":"/people/300","requestId":"test-request-id"}`
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+}
+
+func TestUpdatePersonWithIfMatchReturnsOk(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	store = NewMockStore(mockCtrl)
+	mockStore := store.(*MockStore)
+
+	p := Person{Id:1, Name:"Peter", PhoneNr:"56468465613275", Version:2}
+	mockStore.EXPECT().getPerson(gomock.Any(), 1).Return(Person{Id:1, Name:"Peter", PhoneNr:"24525345626", Version:2}, nil).Times(1)
+	mockStore.EXPECT().updatePerson(gomock.Any(), p).Return(Person{Id:1, Name:"Peter", PhoneNr:"56468465613275", Version:3}, nil).Times(1)
+
+	v := url.Values{}
+	v.Set("name", "Peter")
+	v.Add("phoneNr", "56468465613275")
+
+	req, err := http.NewRequest("PUT", "/people/1", strings.NewReader(v.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("If-Match", `"2"`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	router := createRouter()
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusOK
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+
+	expectedETag := `"3"`
+	if eTag := rr.Header().Get("ETag"); eTag != expectedETag {
+		t.Errorf("handler returned wrong ETag: got %v want %v",
+			eTag, expectedETag)
+	}
+}
+
+func TestUpdatePersonWithIfMatchReturnsPreconditionFailed(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	store = NewMockStore(mockCtrl)
+	mockStore := store.(*MockStore)
+
+	mockStore.EXPECT().getPerson(gomock.Any(), 1).Return(Person{Id:1, Name:"Peter", PhoneNr:"24525345626", Version:3}, nil).Times(1)
+
+	v := url.Values{}
+	v.Set("name", "Peter")
+	v.Add("phoneNr", "56468465613275")
+
+	req, err := http.NewRequest("PUT", "/people/1", strings.NewReader(v.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("If-Match", `"2"`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	router := createRouter()
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusPreconditionFailed
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+
+	expectedBody := `{"type":"about:blank","title":"Precondition Failed","status":412,"detail":"Person 1 was modified, the current ETag is \"3\"","instance":"/people/1","requestId":"test-request-id"}`
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+}
+
+func TestUpdatePersonReturnsErrorIncompletePerson(t *testing.T) {
+	v := url.Values{}
+	v.Set("name", "Peter")
+
+	req, err := http.NewRequest("PUT", "/people/1", strings.NewReader(v.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	router := createRouter()
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusBadRequest
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+
+	expectedBody := `{"type":"/problems/validation-error","title":"Validation failed","status":400,"detail":"One or more fields are invalid","instance":"/people/1","errors":[{"field":"phoneNr","message":"must not be empty"}],"requestId":"test-request-id"}`
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+}
+
+func TestUpdatePersonReturnsErrorMissingId(t *testing.T) {
+	v := url.Values{}
+	v.Set("name", "Peter")
+
+	req, err := http.NewRequest("PUT", "/people", strings.NewReader(v.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	router := createRouter()
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusMethodNotAllowed
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+
+	expectedBody := `{"type":"about:blank","title":"Method Not Allowed","status":405,"detail":"Method PUT is not allowed on /people","instance":"/people","requestId":"test-request-id"}`
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+}
+
+func TestUpdatePersonReturnsErrorBadId(t *testing.T) {
+	v := url.Values{}
+	v.Set("name", "Peter")
+
+	req, err := http.NewRequest("PUT", "/people/a", strings.NewReader(v.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	router := createRouter()
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusBadRequest
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+
+	expectedBody := `{"type":"/problems/invalid-id","title":"Invalid person id","status":400,"detail":"id must be an integer, got \"a\"","instance":"/people/a","requestId":"test-request-id"}`
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+}
+
+func TestUpdatePersonReturnsOk(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	store = NewMockStore(mockCtrl)
+	mockStore := store.(*MockStore)
+
+	p := Person{Id:1, Name:"Peter", PhoneNr:"56468465613275"}
+	mockStore.EXPECT().updatePerson(gomock.Any(), p).Return(p, nil).Times(1)
+
+	v := url.Values{}
+	v.Set("name", "Peter")
+	v.Add("phoneNr", "56468465613275")
+	v.Add("id", "1")
+
+	req, err := http.NewRequest("PUT", "/people/1", strings.NewReader(v.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	router := createRouter()
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusOK
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+
+	expectedBody := ``
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+}
+
+func TestUpdatePersonJsonReturnsOk(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	store = NewMockStore(mockCtrl)
+	mockStore := store.(*MockStore)
+
+	p := Person{Id:1, Name:"Peter", PhoneNr:"56468465613275"}
+	mockStore.EXPECT().updatePerson(gomock.Any(), p).Return(p, nil).Times(1)
+
+	body := `{"id":7,"name":"Peter","phoneNr":"56468465613275"}`
+
+	req, err := http.NewRequest("PUT", "/people/1", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	router := createRouter()
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusOK
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+
+	expectedBody := ``
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+}
+
+func TestUpdatePersonJsonReturnsErrorUnknownField(t *testing.T) {
+	body := `{"name":"Peter","phone":"56468465613275"}`
+
+	req, err := http.NewRequest("PUT", "/people/1", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	router := createRouter()
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusBadRequest
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+
+	expectedBody := `{"type":"/problems/invalid-body","title":"Invalid request body","status":400,"detail":"invalid JSON body: json: unknown field \"phone\"","instance":"/people/1","requestId":"test-request-id"}`
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+}
+
+func TestPatchPersonWithMergePatchReturnsOk(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	store = NewMockStore(mockCtrl)
+	mockStore := store.(*MockStore)
+
+	mockStore.EXPECT().getPerson(gomock.Any(), 1).Return(Person{Id:1, Name:"Peter", PhoneNr:"56468465613275"}, nil).Times(1)
+	mockStore.EXPECT().updatePerson(gomock.Any(), Person{Id:1, Name:"Peter", PhoneNr:"0791234567"}).Return(Person{Id:1, Name:"Peter", PhoneNr:"0791234567"}, nil).Times(1)
+
+	body := `{"phoneNr":"0791234567"}`
+
+	req, err := http.NewRequest("PATCH", "/people/1", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	router := createRouter()
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusOK
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+
+	expectedBody := `{"id":1,"name":"Peter","phoneNr":"0791234567"}`
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+}
+
+func TestPatchPersonWithJsonPatchReturnsOk(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	store = NewMockStore(mockCtrl)
+	mockStore := store.(*MockStore)
+
+	mockStore.EXPECT().getPerson(gomock.Any(), 1).Return(Person{Id:1, Name:"Peter", PhoneNr:"56468465613275"}, nil).Times(1)
+	mockStore.EXPECT().updatePerson(gomock.Any(), Person{Id:1, Name:"Petra", PhoneNr:"56468465613275"}).Return(Person{Id:1, Name:"Petra", PhoneNr:"56468465613275"}, nil).Times(1)
+
+	body := `[{"op":"test","path":"/name","value":"Peter"},{"op":"replace","path":"/name","value":"Petra"}]`
+
+	req, err := http.NewRequest("PATCH", "/people/1", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json-patch+json")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	router := createRouter()
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusOK
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+
+	expectedBody := `{"id":1,"name":"Petra","phoneNr":"56468465613275"}`
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+}
+
+func TestPatchPersonReturnsConflictForFailedTest(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	store = NewMockStore(mockCtrl)
+	mockStore := store.(*MockStore)
+
+	mockStore.EXPECT().getPerson(gomock.Any(), 1).Return(Person{Id:1, Name:"Paul", PhoneNr:"56468465613275"}, nil).Times(1)
+
+	body := `[{"op":"test","path":"/name","value":"Peter"},{"op":"replace","path":"/name","value":"Petra"}]`
+
+	req, err := http.NewRequest("PATCH", "/people/1", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json-patch+json")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	router := createRouter()
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusConflict
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+}
+
+func TestPatchPersonReturnsUnprocessableEntity(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	store = NewMockStore(mockCtrl)
+	mockStore := store.(*MockStore)
+
+	mockStore.EXPECT().getPerson(gomock.Any(), 1).Return(Person{Id:1, Name:"Peter", PhoneNr:"56468465613275"}, nil).Times(1)
+
+	body := `{"id":2,"name":null}`
+
+	req, err := http.NewRequest("PATCH", "/people/1", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	router := createRouter()
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusUnprocessableEntity
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+
+	expectedBody := `{"type":"/problems/validation-error","title":"Validation failed","status":422,"detail":"One or more fields are invalid","instance":"/people/1","errors":[{"field":"name","message":"must not be empty"},{"field":"id","message":"must not be changed"}],"requestId":"test-request-id"}`
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+}
+
+func TestPatchPersonReturnsUnsupportedMediaType(t *testing.T) {
+	body := `{"phoneNr":"0791234567"}`
+
+	req, err := http.NewRequest("PATCH", "/people/1", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	router := createRouter()
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusUnsupportedMediaType
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+
+	expectedAcceptPatch := "application/merge-patch+json, application/json-patch+json"
+	if acceptPatch := rr.Header().Get("Accept-Patch"); acceptPatch != expectedAcceptPatch {
+		t.Errorf("handler returned wrong Accept-Patch header: got %v want %v",
+			acceptPatch, expectedAcceptPatch)
+	}
+}
+
+func TestPatchPersonWithIfMatchReturnsPreconditionFailed(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	store = NewMockStore(mockCtrl)
+	mockStore := store.(*MockStore)
+
+	mockStore.EXPECT().getPerson(gomock.Any(), 1).Return(Person{Id:1, Name:"Peter", PhoneNr:"56468465613275", Version:5}, nil).Times(1)
+
+	body := `{"phoneNr":"0791234567"}`
+
+	req, err := http.NewRequest("PATCH", "/people/1", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	req.Header.Set("If-Match", `"4"`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	router := createRouter()
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusPreconditionFailed
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+}
+
+func TestPatchPersonReturnsConflictForConcurrentChange(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	store = NewMockStore(mockCtrl)
+	mockStore := store.(*MockStore)
+
+	mockStore.EXPECT().getPerson(gomock.Any(), 1).Return(Person{Id:1, Name:"Peter", PhoneNr:"56468465613275", Version:5}, nil).Times(1)
+	mockStore.EXPECT().updatePerson(gomock.Any(), Person{Id:1, Name:"Peter", PhoneNr:"0791234567", Version:5}).Return(Person{}, ErrVersionMismatch).Times(1)
+
+	body := `{"phoneNr":"0791234567"}`
+
+	req, err := http.NewRequest("PATCH", "/people/1", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	router := createRouter()
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusConflict
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+}
+
+func TestDeletePersonReturnsBadRequest(t *testing.T) {
+	req, err := http.NewRequest("DELETE", "/people/a", nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	router := createRouter()
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusBadRequest
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+
+	expectedBody := `{"type":"/problems/invalid-id","title":"Invalid person id","status":400,"detail":"id must be an integer, got \"a\"","instance":"/people/a","requestId":"test-request-id"}`
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+}
+
+func TestDeletePersonReturnsInternalServerError(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	store = NewMockStore(mockCtrl)
+	mockStore := store.(*MockStore)
+
+	mockStore.EXPECT().deletePerson(gomock.Any(), 1, 0).Return(errors.New("Error in deletePerson")).Times(1)
+
+	req, err := http.NewRequest("DELETE", "/people/1", nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	router := createRouter()
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusInternalServerError
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+
+	expectedBody := `{"type":"about:blank","title":"Internal Server Error","status":500,"detail":"Could not delete person 1","instance":"/people/1","requestId":"test-request-id"}`
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+}
+
+func TestDeletePersonReturnsNotFound(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	store = NewMockStore(mockCtrl)
+	mockStore := store.(*MockStore)
+
+	mockStore.EXPECT().deletePerson(gomock.Any(), 300, 0).Return(ErrNotFound).Times(1)
+
+	req, err := http.NewRequest("DELETE", "/people/300", nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	router := createRouter()
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusNotFound
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+
+	expectedBody := `{"type":"about:blank","title":"Not Found","status":404,"detail":"Person 300 does not exist","instance":"/people/300","requestId":"test-request-id"}`
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+}
+
+func TestDeletePersonWithIfMatchReturnsPreconditionFailed(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	store = NewMockStore(mockCtrl)
+	mockStore := store.(*MockStore)
+
+	mockStore.EXPECT().getPerson(gomock.Any(), 1).Return(Person{Id:1, Name:"Peter", PhoneNr:"56468465613275", Version:2}, nil).Times(1)
+	mockStore.EXPECT().deletePerson(gomock.Any(), 1, 2).Return(ErrVersionMismatch).Times(1)
+
+	req, err := http.NewRequest("DELETE", "/people/1", nil)
+	req.Header.Set("If-Match", `"1", "2"`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	router := createRouter()
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusPreconditionFailed
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+}
+
+func TestDeletePersonReturnsOk(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	store = NewMockStore(mockCtrl)
+	mockStore := store.(*MockStore)
+
+	mockStore.EXPECT().deletePerson(gomock.Any(), 1, 0).Return(nil).Times(1)
+
+	req, err := http.NewRequest("DELETE", "/people/1", nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	router := createRouter()
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusOK
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+
+	expectedBody := ``
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+}
+func TestHealthzReturnsOk(t *testing.T) {
+	req, err := http.NewRequest("GET", "/healthz", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	router := createRouter()
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusOK
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+
+	expectedBody := `{"status":"ok"}`
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+}
+
+func TestReadyzReturnsOk(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	store = NewMockStore(mockCtrl)
+	mockStore := store.(*MockStore)
+
+	mockStore.EXPECT().ping(gomock.Any()).Return(nil).Times(1)
+
+	req, err := http.NewRequest("GET", "/readyz", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	router := createRouter()
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusOK
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+
+	expectedBody := `{"status":"ok","components":[{"name":"server","status":"ok"},{"name":"store","status":"ok"}]}`
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+}
+
+func TestReadyzReturnsServiceUnavailable(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	store = NewMockStore(mockCtrl)
+	mockStore := store.(*MockStore)
+
+	mockStore.EXPECT().ping(gomock.Any()).Return(fmt.Errorf("%w: migration 3 add_people_search_indexes is pending", ErrUnavailable)).Times(1)
+
+	req, err := http.NewRequest("GET", "/readyz", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	router := createRouter()
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusServiceUnavailable
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+
+	expectedBody := `{"status":"unavailable","components":[{"name":"server","status":"ok"},{"name":"store","status":"unavailable","detail":"store unavailable: migration 3 add_people_search_indexes is pending"}]}`
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+}
+
+func TestReadyzFailsWhileDraining(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	store = NewMockStore(mockCtrl)
+	mockStore := store.(*MockStore)
+
+	mockStore.EXPECT().ping(gomock.Any()).Return(nil).Times(1)
+
+	draining.Store(true)
+	defer draining.Store(false)
+
+	req, err := http.NewRequest("GET", "/readyz", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	router := createRouter()
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusServiceUnavailable
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+
+	expectedBody := `{"status":"unavailable","components":[{"name":"server","status":"unavailable","detail":"shutting down"},{"name":"store","status":"ok"}]}`
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+}
@@ -1,35 +1,395 @@
 package main
 
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps people in memory. It is safe for concurrent use, the
+// lock guards all other fields.
 type MemoryStore struct {
+	lock sync.RWMutex
 	id int
 	people map[int]Person
+	// counts holds the number of people per tenant that are not deleted
+	counts map[string]int
+	index *searchIndex
+	// audit holds the audit entries of all tenants, ids count up from 1
+	audit []AuditEntry
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{id: 1, people: make(map[int]Person), counts: make(map[string]int), index: newSearchIndex()}
 }
 
-func (store *MemoryStore) getPeople() ([]Person, error) {
+func (store *MemoryStore) getPeople(ctx context.Context, query PeopleQuery) ([]Person, int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	tenant := tenantFrom(ctx)
 	personList := []Person{}
 	for _, value := range store.people {
-		personList = append(personList, value)
+		if value.Tenant == tenant {
+			personList = append(personList, value)
+		}
 	}
-	return personList, nil
+	page, total := queryPeople(personList, query)
+	return page, total, nil
 }
 
-func (store *MemoryStore) getPerson(id int) (Person, error) {
-	return store.people[id], nil
+func (store *MemoryStore) getPerson(ctx context.Context, id int) (Person, error) {
+	if err := ctx.Err(); err != nil {
+		return Person{}, err
+	}
+
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	person, ok := store.people[id]
+	if !ok || person.Tenant != tenantFrom(ctx) || person.DeletedAt != nil {
+		return Person{}, ErrNotFound
+	}
+	return person, nil
 }
 
-func (store *MemoryStore) createPerson(p Person) error {
+func (store *MemoryStore) createPerson(ctx context.Context, p Person) (Person, error) {
+	if err := ctx.Err(); err != nil {
+		return Person{}, err
+	}
+	if err := checkPerson(p); err != nil {
+		return Person{}, err
+	}
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	p.Tenant = tenantFrom(ctx)
+	if err := quotas.exceeded(p.Tenant, store.counts[p.Tenant]); err != nil {
+		return Person{}, err
+	}
 	p.Id = store.id
-	store.people[p.Id] = p
-	store.id++
+	p.Version = 1
+	p.CreatedAt = storeTime()
+	p.UpdatedAt = p.CreatedAt
+	p.DeletedAt = nil
+	store.set(p)
+	store.appendAudit(newAuditEntry(ctx, auditCreate, p.CreatedAt, nil, &p))
+	return p, nil
+}
+
+func (store *MemoryStore) updatePerson(ctx context.Context, p Person) (Person, error) {
+	if err := ctx.Err(); err != nil {
+		return Person{}, err
+	}
+	if err := checkPerson(p); err != nil {
+		return Person{}, err
+	}
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	old, ok := store.people[p.Id]
+	if !ok || old.Tenant != tenantFrom(ctx) || old.DeletedAt != nil {
+		return Person{}, ErrNotFound
+	}
+	if p.Version != 0 && p.Version != old.Version {
+		return Person{}, ErrVersionMismatch
+	}
+	p.Version = old.Version + 1
+	p.Tenant = old.Tenant
+	p.CreatedAt = old.CreatedAt
+	p.UpdatedAt = storeTime()
+	p.DeletedAt = nil
+	store.set(p)
+	store.appendAudit(newAuditEntry(ctx, auditUpdate, p.UpdatedAt, &old, &p))
+	return p, nil
+}
+
+func (store *MemoryStore) deletePerson(ctx context.Context, id int, version int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	old, ok := store.people[id]
+	if !ok || old.Tenant != tenantFrom(ctx) || old.DeletedAt != nil {
+		return ErrNotFound
+	}
+	if version != 0 && version != old.Version {
+		return ErrVersionMismatch
+	}
+	deleted := old
+	deletedAt := storeTime()
+	deleted.DeletedAt = &deletedAt
+	deleted.UpdatedAt = deletedAt
+	deleted.Version = old.Version + 1
+	store.set(deleted)
+	store.appendAudit(newAuditEntry(ctx, auditDelete, deletedAt, &old, nil))
 	return nil
 }
 
-func (store *MemoryStore) updatePerson(p Person) error {
+func (store *MemoryStore) restorePerson(ctx context.Context, id int, version int) (Person, error) {
+	if err := ctx.Err(); err != nil {
+		return Person{}, err
+	}
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	old, err := store.deleted(ctx, id)
+	if err != nil {
+		return Person{}, err
+	}
+	if version != 0 && version != old.Version {
+		return Person{}, ErrVersionMismatch
+	}
+	if err := quotas.exceeded(old.Tenant, store.counts[old.Tenant]); err != nil {
+		return Person{}, err
+	}
+	p := old
+	p.DeletedAt = nil
+	p.UpdatedAt = storeTime()
+	p.Version = old.Version + 1
+	store.set(p)
+	store.appendAudit(newAuditEntry(ctx, auditRestore, p.UpdatedAt, nil, &p))
+	return p, nil
+}
+
+func (store *MemoryStore) purgeDeleted(ctx context.Context, before time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	expired := store.expired(before)
+	for _, p := range expired {
+		store.unset(p.Id)
+		store.appendAudit(newPurgeEntry(p))
+	}
+	return len(expired), nil
+}
+
+func (store *MemoryStore) searchPeople(ctx context.Context, q string, limit int) ([]SearchResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	all := func() []int {
+		ids := []int{}
+		for id := range store.people {
+			ids = append(ids, id)
+		}
+		return ids
+	}
+
+	tenant := tenantFrom(ctx)
+	results := []SearchResult{}
+	for _, id := range store.index.candidates(q, all) {
+		person := store.people[id]
+		if person.Tenant != tenant || person.DeletedAt != nil {
+			continue
+		}
+		if score := scorePerson(q, person); score > 0 {
+			results = append(results, SearchResult{person, score})
+		}
+	}
+	return sortResults(results, limit), nil
+}
+
+func (store *MemoryStore) getAudit(ctx context.Context, query AuditQuery) ([]AuditEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	tenant := tenantFrom(ctx)
+	entries := []AuditEntry{}
+	for _, entry := range store.audit {
+		if entry.Tenant != tenant || !query.matches(entry) {
+			continue
+		}
+		entries = append(entries, entry)
+		if query.Limit > 0 && len(entries) == query.Limit {
+			break
+		}
+	}
+	return entries, nil
+}
+
+func (store *MemoryStore) ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// put stores p as it is, replacing a person with the same id. It is used
+// by stores that keep their state in memory but decide on changes themselves.
+func (store *MemoryStore) put(p Person) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	store.set(p)
+}
+
+// remove deletes the person with the given id if it exists
+func (store *MemoryStore) remove(id int) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	store.unset(id)
+}
+
+// nextId returns the id the next created person gets
+func (store *MemoryStore) nextId() int {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	return store.id
+}
+
+// nextAuditId returns the id the next audit entry gets
+func (store *MemoryStore) nextAuditId() int {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	return len(store.audit) + 1
+}
+
+// addAudit appends entry to the audit trail unless an entry with its id is
+// already recorded, for stores replaying their changes
+func (store *MemoryStore) addAudit(entry AuditEntry) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	store.appendAudit(entry)
+}
+
+// auditTrail returns the audit entries of every tenant
+func (store *MemoryStore) auditTrail() []AuditEntry {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	return append([]AuditEntry{}, store.audit...)
+}
+
+// count returns the number of people tenant has that are not deleted
+func (store *MemoryStore) count(tenant string) int {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	return store.counts[tenant]
+}
+
+// getDeleted returns the deleted person with the given id of the tenant of
+// ctx
+func (store *MemoryStore) getDeleted(ctx context.Context, id int) (Person, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	return store.deleted(ctx, id)
+}
+
+// expiredPeople returns the people of every tenant deleted before the
+// given time ordered by id
+func (store *MemoryStore) expiredPeople(before time.Time) []Person {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	return store.expired(before)
+}
+
+// all returns every person of every tenant ordered by id, including the
+// deleted ones
+func (store *MemoryStore) all() []Person {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	people := []Person{}
+	for _, person := range store.people {
+		people = append(people, person)
+	}
+	page, _ := queryPeople(people, PeopleQuery{IncludeDeleted: true})
+	return page
+}
+
+// deleted returns the deleted person with the given id of the tenant of
+// ctx, the lock must be held
+func (store *MemoryStore) deleted(ctx context.Context, id int) (Person, error) {
+	person, ok := store.people[id]
+	if !ok || person.Tenant != tenantFrom(ctx) {
+		return Person{}, ErrNotFound
+	}
+	if person.DeletedAt == nil {
+		return Person{}, ErrNotDeleted
+	}
+	return person, nil
+}
+
+// expired returns the people deleted before the given time ordered by id,
+// the lock must be held
+func (store *MemoryStore) expired(before time.Time) []Person {
+	people := []Person{}
+	for _, person := range store.people {
+		if person.DeletedAt != nil && person.DeletedAt.Before(before) {
+			people = append(people, person)
+		}
+	}
+	sort.Slice(people, func(i, j int) bool {
+		return people[i].Id < people[j].Id
+	})
+	return people
+}
+
+// set stores p and updates the index, the lock must be held
+func (store *MemoryStore) set(p Person) {
+	if old, ok := store.people[p.Id]; ok {
+		store.index.remove(old)
+		if old.DeletedAt == nil {
+			store.counts[old.Tenant]--
+		}
+	}
 	store.people[p.Id] = p
-	return nil
+	if p.DeletedAt == nil {
+		store.counts[p.Tenant]++
+	}
+	store.index.add(p)
+	if p.Id >= store.id {
+		store.id = p.Id + 1
+	}
 }
 
-func (store *MemoryStore) deletePerson(id int) error {
-	delete(store.people, id)
-	return nil
+// appendAudit records entry, giving it the next id if it has none, the lock
+// must be held
+func (store *MemoryStore) appendAudit(entry AuditEntry) {
+	if entry.Id == 0 {
+		entry.Id = len(store.audit) + 1
+	}
+	if entry.Id <= len(store.audit) {
+		return
+	}
+	store.audit = append(store.audit, entry)
+}
+
+// unset deletes the person with the given id, the lock must be held
+func (store *MemoryStore) unset(id int) {
+	if old, ok := store.people[id]; ok {
+		store.index.remove(old)
+		if old.DeletedAt == nil {
+			store.counts[old.Tenant]--
+		}
+		delete(store.people, id)
+	}
 }
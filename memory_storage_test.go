@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestMemoryStoreReturnsStoreErrors(t *testing.T) {
+	memoryStore := NewMemoryStore()
+
+	if _, err := memoryStore.getPerson(context.Background(), 1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("getPerson returned wrong error: got %v want %v", err, ErrNotFound)
+	}
+
+	if _, err := memoryStore.updatePerson(context.Background(), Person{Id:1, Name:"Peter", PhoneNr:"24525345626"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("updatePerson returned wrong error: got %v want %v", err, ErrNotFound)
+	}
+
+	if err := memoryStore.deletePerson(context.Background(), 1, 0); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deletePerson returned wrong error: got %v want %v", err, ErrNotFound)
+	}
+
+	if _, err := memoryStore.createPerson(context.Background(), Person{Name:"Peter"}); !errors.Is(err, ErrValidation) {
+		t.Errorf("createPerson returned wrong error: got %v want %v", err, ErrValidation)
+	}
+}
+
+func TestMemoryStoreStopsOnCanceledContext(t *testing.T) {
+	memoryStore := NewMemoryStore()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := memoryStore.createPerson(ctx, Person{Name:"Peter", PhoneNr:"24525345626"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("createPerson returned wrong error: got %v want %v", err, context.Canceled)
+	}
+	if _, _, err := memoryStore.getPeople(ctx, PeopleQuery{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("getPeople returned wrong error: got %v want %v", err, context.Canceled)
+	}
+	if people := memoryStore.all(); len(people) != 0 {
+		t.Errorf("createPerson stored a person with a canceled context: %+v", people)
+	}
+}
+
+func TestMemoryStoreReturnsPagesInIdOrder(t *testing.T) {
+	memoryStore := NewMemoryStore()
+	for _, name := range []string{"Paul", "Peter", "Alice", "Bob", "Carol"} {
+		if _, err := memoryStore.createPerson(context.Background(), Person{Name:name, PhoneNr:"24525345626"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		query       PeopleQuery
+		expectedIds []int
+	}{
+		{PeopleQuery{}, []int{1, 2, 3, 4, 5}},
+		{PeopleQuery{Limit:2}, []int{1, 2}},
+		{PeopleQuery{Limit:2, Offset:4}, []int{5}},
+		{PeopleQuery{Limit:2, Offset:9}, []int{}},
+		{PeopleQuery{Limit:2, After:&Person{Id:2}}, []int{3, 4}},
+		{PeopleQuery{Limit:2, Before:&Person{Id:5}}, []int{3, 4}},
+		{PeopleQuery{Limit:3, Before:&Person{Id:3}}, []int{1, 2}},
+	}
+
+	for _, test := range tests {
+		people, total, err := memoryStore.getPeople(context.Background(), test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total != 5 {
+			t.Errorf("getPeople(%+v) returned wrong total: got %v want %v", test.query, total, 5)
+		}
+		ids := []int{}
+		for _, person := range people {
+			ids = append(ids, person.Id)
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(test.expectedIds) {
+			t.Errorf("getPeople(%+v) returned wrong ids: got %v want %v", test.query, ids, test.expectedIds)
+		}
+	}
+}
+
+func TestMemoryStoreFiltersAndSortsPeople(t *testing.T) {
+	memoryStore := NewMemoryStore()
+	for _, p := range []Person{{Name:"Peter", PhoneNr:"0791"}, {Name:"Paul", PhoneNr:"0792"}, {Name:"Alice", PhoneNr:"0791"}, {Name:"Peter", PhoneNr:"0441"}} {
+		if _, err := memoryStore.createPerson(context.Background(), p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	byName := []SortField{{Field:"name"}}
+	byNameDesc := []SortField{{Field:"name", Descending:true}}
+	tests := []struct {
+		query         PeopleQuery
+		expectedIds   []int
+		expectedTotal int
+	}{
+		{PeopleQuery{Name:"Peter"}, []int{1, 4}, 2},
+		{PeopleQuery{NamePrefix:"P"}, []int{1, 2, 4}, 3},
+		{PeopleQuery{PhoneNrPrefix:"079"}, []int{1, 2, 3}, 3},
+		{PeopleQuery{NamePrefix:"Pe", PhoneNrPrefix:"044"}, []int{4}, 1},
+		{PeopleQuery{Sort:byName}, []int{3, 2, 1, 4}, 4},
+		{PeopleQuery{Sort:byNameDesc}, []int{1, 4, 2, 3}, 4},
+		{PeopleQuery{Sort:[]SortField{{Field:"phoneNr"}, {Field:"id", Descending:true}}}, []int{4, 3, 1, 2}, 4},
+		{PeopleQuery{Sort:byName, Limit:2, After:&Person{Id:2, Name:"Paul"}}, []int{1, 4}, 4},
+		{PeopleQuery{Sort:byNameDesc, Limit:2, Before:&Person{Id:3, Name:"Alice"}}, []int{4, 2}, 4},
+	}
+
+	for _, test := range tests {
+		people, total, err := memoryStore.getPeople(context.Background(), test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total != test.expectedTotal {
+			t.Errorf("getPeople(%+v) returned wrong total: got %v want %v", test.query, total, test.expectedTotal)
+		}
+		ids := []int{}
+		for _, person := range people {
+			ids = append(ids, person.Id)
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(test.expectedIds) {
+			t.Errorf("getPeople(%+v) returned wrong ids: got %v want %v", test.query, ids, test.expectedIds)
+		}
+	}
+}
+
+func TestMemoryStoreSearchesPeople(t *testing.T) {
+	memoryStore := NewMemoryStore()
+	for _, p := range []Person{{Name:"Peter Muster", PhoneNr:"+41 79 123 45 67"}, {Name:"Paul", PhoneNr:"044 555 12 12"}, {Name:"Alice", PhoneNr:"031 987 65 43"}} {
+		if _, err := memoryStore.createPerson(context.Background(), p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		q           string
+		expectedIds []int
+	}{
+		{"Petr", []int{1}},
+		{"muster", []int{1}},
+		{"Alcie", []int{}},
+		{"Alice", []int{3}},
+		{"123-45", []int{1}},
+		{"12", []int{2, 1}},
+		{"Bob", []int{}},
+	}
+
+	for _, test := range tests {
+		results, err := memoryStore.searchPeople(context.Background(), test.q, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := []int{}
+		for _, result := range results {
+			ids = append(ids, result.Id)
+			if result.Score <= 0 || result.Score > 1 {
+				t.Errorf("searchPeople(%q) returned score out of range: %v", test.q, result.Score)
+			}
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(test.expectedIds) {
+			t.Errorf("searchPeople(%q) returned wrong ids: got %v want %v", test.q, ids, test.expectedIds)
+		}
+	}
+
+	if _, err := memoryStore.updatePerson(context.Background(), Person{Id:1, Name:"Bob", PhoneNr:"+41 79 123 45 67"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := memoryStore.deletePerson(context.Background(), 3, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if results, _ := memoryStore.searchPeople(context.Background(), "Peter", 10); len(results) != 0 {
+		t.Errorf("searchPeople found the old name after update: %v", results)
+	}
+	if results, _ := memoryStore.searchPeople(context.Background(), "Alice", 10); len(results) != 0 {
+		t.Errorf("searchPeople found a deleted person: %v", results)
+	}
+	if results, _ := memoryStore.searchPeople(context.Background(), "Bob", 10); len(results) != 1 {
+		t.Errorf("searchPeople did not find the new name after update: %v", results)
+	}
+}
+
+func TestMemoryStoreChecksVersions(t *testing.T) {
+	memoryStore := NewMemoryStore()
+
+	p, err := memoryStore.createPerson(context.Background(), Person{Name:"Peter", PhoneNr:"24525345626"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Version != 1 {
+		t.Errorf("createPerson returned wrong version: got %v want %v", p.Version, 1)
+	}
+
+	p.PhoneNr = "0791234567"
+	updated, err := memoryStore.updatePerson(context.Background(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Version != 2 {
+		t.Errorf("updatePerson returned wrong version: got %v want %v", updated.Version, 2)
+	}
+
+	if _, err := memoryStore.updatePerson(context.Background(), p); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("updatePerson with old version returned wrong error: got %v want %v", err, ErrVersionMismatch)
+	}
+	if err := memoryStore.deletePerson(context.Background(), p.Id, 1); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("deletePerson with old version returned wrong error: got %v want %v", err, ErrVersionMismatch)
+	}
+	if err := memoryStore.deletePerson(context.Background(), p.Id, 2); err != nil {
+		t.Errorf("deletePerson with current version returned error: %v", err)
+	}
+}
+
+// Run with go test -race to detect unsynchronized access
+func TestMemoryStoreIsSafeForConcurrentUse(t *testing.T) {
+	memoryStore := NewMemoryStore()
+
+	const workers = 8
+	const iterations = 50
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				p, err := memoryStore.createPerson(context.Background(), Person{Name:fmt.Sprintf("Peter %d", w), PhoneNr:fmt.Sprintf("079%07d", i)})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if _, err := memoryStore.getPerson(context.Background(), p.Id); err != nil {
+					t.Error(err)
+					return
+				}
+				p.Name = fmt.Sprintf("Paul %d", w)
+				if _, err := memoryStore.updatePerson(context.Background(), p); err != nil {
+					t.Error(err)
+					return
+				}
+				if _, _, err := memoryStore.getPeople(context.Background(), PeopleQuery{Limit:10, Sort:[]SortField{{Field:"name"}}}); err != nil {
+					t.Error(err)
+					return
+				}
+				if _, err := memoryStore.searchPeople(context.Background(), "Paul", 5); err != nil {
+					t.Error(err)
+					return
+				}
+				if i%2 == 0 {
+					if err := memoryStore.deletePerson(context.Background(), p.Id, 0); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	people, total, err := memoryStore.getPeople(context.Background(), PeopleQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedTotal := workers * iterations / 2
+	if total != expectedTotal || len(people) != expectedTotal {
+		t.Errorf("getPeople returned wrong number of people: got %v (total %v) want %v", len(people), total, expectedTotal)
+	}
+
+	ids := map[int]bool{}
+	for i, person := range people {
+		if ids[person.Id] {
+			t.Errorf("getPeople returned id %v twice", person.Id)
+		}
+		ids[person.Id] = true
+		if i > 0 && people[i-1].Id >= person.Id {
+			t.Errorf("getPeople returned ids out of order: %v before %v", people[i-1].Id, person.Id)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsRegistry holds the metrics served on /metrics. It is not the
+// global registry, so tests can build as many routers as they need.
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Handled HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Time taken to handle HTTP requests by route, method and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	storeOperationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "store_operation_duration_seconds",
+		Help:    "Time taken by store operations.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	storeErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "store_errors_total",
+		Help: "Failed store operations by operation and kind of error.",
+	}, []string{"operation", "error"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests,
+		httpRequestDuration,
+		storeOperationDuration,
+		storeErrors,
+	)
+}
+
+// Metrics serves the metrics in the Prometheus text format
+var Metrics = promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+
+// statusRecorder remembers the status code and the number of bytes
+// written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (recorder *statusRecorder) WriteHeader(status int) {
+	if recorder.status == 0 {
+		recorder.status = status
+	}
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+func (recorder *statusRecorder) Write(b []byte) (int, error) {
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+	n, err := recorder.ResponseWriter.Write(b)
+	recorder.bytes += n
+	return n, err
+}
+
+// recordRequestMetrics counts the requests of a route and observes how long
+// they took. Routes are labeled with their path template, so all people
+// share the label /people/{id}.
+func recordRequestMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+
+		route := "unknown"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		status := strconv.Itoa(recorder.status)
+		httpRequests.WithLabelValues(route, r.Method, status).Inc()
+		httpRequestDuration.WithLabelValues(route, r.Method, status).Observe(time.Since(start).Seconds())
+	})
+}
+
+// registerPoolMetrics exports the connection pool statistics of a database
+// backed store
+func registerPoolMetrics(s Store) {
+	if db, ok := s.(*dbStore); ok {
+		metricsRegistry.MustRegister(collectors.NewDBStatsCollector(db.db.DB(), db.db.Dialect().GetName()))
+	}
+}
+
+// metricsStore records the latency and the errors of every operation of
+// the store it wraps
+type metricsStore struct {
+	next Store
+}
+
+func newMetricsStore(next Store) *metricsStore {
+	return &metricsStore{next}
+}
+
+// observe records an operation that started at start and ended with err
+func (store *metricsStore) observe(operation string, start time.Time, err error) {
+	storeOperationDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		storeErrors.WithLabelValues(operation, errorKind(err)).Inc()
+	}
+}
+
+func (store *metricsStore) getPeople(ctx context.Context, query PeopleQuery) ([]Person, int, error) {
+	start := time.Now()
+	people, total, err := store.next.getPeople(ctx, query)
+	store.observe("getPeople", start, err)
+	return people, total, err
+}
+
+func (store *metricsStore) getPerson(ctx context.Context, id int) (Person, error) {
+	start := time.Now()
+	person, err := store.next.getPerson(ctx, id)
+	store.observe("getPerson", start, err)
+	return person, err
+}
+
+func (store *metricsStore) createPerson(ctx context.Context, p Person) (Person, error) {
+	start := time.Now()
+	person, err := store.next.createPerson(ctx, p)
+	store.observe("createPerson", start, err)
+	return person, err
+}
+
+func (store *metricsStore) updatePerson(ctx context.Context, p Person) (Person, error) {
+	start := time.Now()
+	person, err := store.next.updatePerson(ctx, p)
+	store.observe("updatePerson", start, err)
+	return person, err
+}
+
+func (store *metricsStore) deletePerson(ctx context.Context, id int, version int) error {
+	start := time.Now()
+	err := store.next.deletePerson(ctx, id, version)
+	store.observe("deletePerson", start, err)
+	return err
+}
+
+func (store *metricsStore) restorePerson(ctx context.Context, id int, version int) (Person, error) {
+	start := time.Now()
+	person, err := store.next.restorePerson(ctx, id, version)
+	store.observe("restorePerson", start, err)
+	return person, err
+}
+
+func (store *metricsStore) purgeDeleted(ctx context.Context, before time.Time) (int, error) {
+	start := time.Now()
+	purged, err := store.next.purgeDeleted(ctx, before)
+	store.observe("purgeDeleted", start, err)
+	return purged, err
+}
+
+func (store *metricsStore) searchPeople(ctx context.Context, q string, limit int) ([]SearchResult, error) {
+	start := time.Now()
+	results, err := store.next.searchPeople(ctx, q, limit)
+	store.observe("searchPeople", start, err)
+	return results, err
+}
+
+func (store *metricsStore) getAudit(ctx context.Context, query AuditQuery) ([]AuditEntry, error) {
+	start := time.Now()
+	entries, err := store.next.getAudit(ctx, query)
+	store.observe("getAudit", start, err)
+	return entries, err
+}
+
+func (store *metricsStore) ping(ctx context.Context) error {
+	start := time.Now()
+	err := store.next.ping(ctx)
+	store.observe("ping", start, err)
+	return err
+}
+
+// Close closes the wrapped store if it needs closing
+func (store *metricsStore) Close() error {
+	if closer, ok := store.next.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// errorKind labels err with the store error it wraps
+func errorKind(err error) string {
+	switch {
+	case errors.Is(err, ErrValidation):
+		return "validation"
+	case errors.Is(err, ErrNotFound):
+		return "not_found"
+	case errors.Is(err, ErrVersionMismatch):
+		return "version_mismatch"
+	case errors.Is(err, ErrConflict):
+		return "conflict"
+	case errors.Is(err, ErrNotDeleted):
+		return "not_deleted"
+	case errors.Is(err, ErrQuotaExceeded):
+		return "quota_exceeded"
+	case errors.Is(err, ErrUnavailable):
+		return "unavailable"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	}
+	return "other"
+}
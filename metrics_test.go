@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetricsCountRequestsByRoute(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	store = NewMockStore(mockCtrl)
+	mockStore := store.(*MockStore)
+
+	mockStore.EXPECT().getPerson(gomock.Any(), 3).Return(Person{}, ErrNotFound).Times(1)
+
+	counter := httpRequests.WithLabelValues("/people/{id}", "GET", "404")
+	before := testutil.ToFloat64(counter)
+
+	router := createRouter()
+	req, err := http.NewRequest("GET", "/people/3", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	if count := testutil.ToFloat64(counter) - before; count != 1 {
+		t.Errorf("request was counted wrongly: got %v want %v", count, 1)
+	}
+
+	req, err = http.NewRequest("GET", "/metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusOK
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+	expectedLine := `http_request_duration_seconds_count{method="GET",route="/people/{id}",status="404"}`
+	if !strings.Contains(rr.Body.String(), expectedLine) {
+		t.Errorf("metrics are missing %v", expectedLine)
+	}
+}
+
+func TestMetricsStoreRecordsErrors(t *testing.T) {
+	metrics := newMetricsStore(NewMemoryStore())
+
+	counter := storeErrors.WithLabelValues("getPerson", "not_found")
+	before := testutil.ToFloat64(counter)
+
+	if _, err := metrics.getPerson(context.Background(), 1); err != ErrNotFound {
+		t.Errorf("getPerson returned wrong error: got %v want %v", err, ErrNotFound)
+	}
+	if _, err := metrics.createPerson(context.Background(), Person{Name:"Peter", PhoneNr:"24525345626"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if count := testutil.ToFloat64(counter) - before; count != 1 {
+		t.Errorf("store error was counted wrongly: got %v want %v", count, 1)
+	}
+	if count := testutil.ToFloat64(storeErrors.WithLabelValues("createPerson", "other")); count != 0 {
+		t.Errorf("successful operation was counted as error: got %v", count)
+	}
+}
@@ -0,0 +1,446 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// migration changes the schema from the previous version to Version. The
+// statements are kept per dialect, "postgres" or "sqlite3", a dialect
+// without statements only records the version.
+type migration struct {
+	Version int
+	Name    string
+	Up      map[string][]string
+	Down    map[string][]string
+}
+
+// migrations are applied in this order. An applied migration must never be
+// changed, its checksum is recorded and checked on every run; add a new one.
+var migrations = []migration{
+	{
+		Version: 1,
+		Name:    "create_people",
+		Up: map[string][]string{
+			// Tables created by earlier releases are adopted as they are
+			"postgres": {"CREATE TABLE IF NOT EXISTS people (id serial PRIMARY KEY, name varchar(255), phone_nr varchar(255))"},
+			// SQLite databases always had the version column
+			"sqlite3": {"CREATE TABLE IF NOT EXISTS people (id integer PRIMARY KEY AUTOINCREMENT, name varchar(255), phone_nr varchar(255), version integer NOT NULL DEFAULT 1)"},
+		},
+		Down: map[string][]string{
+			"postgres": {"DROP TABLE IF EXISTS people"},
+			"sqlite3":  {"DROP TABLE IF EXISTS people"},
+		},
+	},
+	{
+		Version: 2,
+		Name:    "add_people_version",
+		Up: map[string][]string{
+			"postgres": {"ALTER TABLE people ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1"},
+		},
+		Down: map[string][]string{
+			"postgres": {"ALTER TABLE people DROP COLUMN IF EXISTS version"},
+		},
+	},
+	{
+		Version: 3,
+		Name:    "add_people_search_indexes",
+		Up: map[string][]string{
+			"postgres": {
+				"CREATE EXTENSION IF NOT EXISTS pg_trgm",
+				"CREATE INDEX IF NOT EXISTS people_name_trgm_idx ON people USING gin (name gin_trgm_ops)",
+				"CREATE INDEX IF NOT EXISTS people_name_tsv_idx ON people USING gin (to_tsvector('simple', name))",
+				"CREATE INDEX IF NOT EXISTS people_phone_digits_trgm_idx ON people USING gin (regexp_replace(phone_nr, '\\D', '', 'g') gin_trgm_ops)",
+			},
+		},
+		Down: map[string][]string{
+			// The extension stays, other databases of the server may use it
+			"postgres": {
+				"DROP INDEX IF EXISTS people_phone_digits_trgm_idx",
+				"DROP INDEX IF EXISTS people_name_tsv_idx",
+				"DROP INDEX IF EXISTS people_name_trgm_idx",
+			},
+		},
+	},
+	{
+		Version: 4,
+		Name:    "add_people_tenant",
+		// Existing people belong to the default tenant
+		Up: map[string][]string{
+			"postgres": {
+				"ALTER TABLE people ADD COLUMN IF NOT EXISTS tenant varchar(64) NOT NULL DEFAULT 'default'",
+				"CREATE INDEX IF NOT EXISTS people_tenant_idx ON people (tenant, id)",
+			},
+			"sqlite3": {
+				"ALTER TABLE people ADD COLUMN tenant varchar(64) NOT NULL DEFAULT 'default'",
+				"CREATE INDEX people_tenant_idx ON people (tenant, id)",
+			},
+		},
+		Down: map[string][]string{
+			"postgres": {
+				"DROP INDEX IF EXISTS people_tenant_idx",
+				"ALTER TABLE people DROP COLUMN IF EXISTS tenant",
+			},
+			"sqlite3": {
+				"DROP INDEX people_tenant_idx",
+				"ALTER TABLE people DROP COLUMN tenant",
+			},
+		},
+	},
+	{
+		Version: 5,
+		Name:    "create_audit_log",
+		// Triggers reject every change of a recorded entry
+		Up: map[string][]string{
+			"postgres": {
+				"CREATE TABLE IF NOT EXISTS audit_log (id bigserial PRIMARY KEY, tenant varchar(64) NOT NULL, person_id integer NOT NULL, actor varchar(255) NOT NULL, op varchar(16) NOT NULL, at timestamptz NOT NULL, before text, after text)",
+				"CREATE INDEX IF NOT EXISTS audit_log_person_idx ON audit_log (tenant, person_id, id)",
+				"CREATE INDEX IF NOT EXISTS audit_log_at_idx ON audit_log (tenant, at, id)",
+				"CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$ BEGIN RAISE EXCEPTION 'audit_log is append-only'; END $$ LANGUAGE plpgsql",
+				"CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE ON audit_log FOR EACH ROW EXECUTE PROCEDURE audit_log_append_only()",
+			},
+			"sqlite3": {
+				"CREATE TABLE audit_log (id integer PRIMARY KEY AUTOINCREMENT, tenant varchar(64) NOT NULL, person_id integer NOT NULL, actor varchar(255) NOT NULL, op varchar(16) NOT NULL, at datetime NOT NULL, before text, after text)",
+				"CREATE INDEX audit_log_person_idx ON audit_log (tenant, person_id, id)",
+				"CREATE INDEX audit_log_at_idx ON audit_log (tenant, at, id)",
+				"CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON audit_log BEGIN SELECT RAISE(ABORT, 'audit_log is append-only'); END",
+				"CREATE TRIGGER audit_log_no_delete BEFORE DELETE ON audit_log BEGIN SELECT RAISE(ABORT, 'audit_log is append-only'); END",
+			},
+		},
+		Down: map[string][]string{
+			"postgres": {
+				"DROP TABLE IF EXISTS audit_log",
+				"DROP FUNCTION IF EXISTS audit_log_append_only()",
+			},
+			"sqlite3": {"DROP TABLE IF EXISTS audit_log"},
+		},
+	},
+	{
+		Version: 6,
+		Name:    "add_people_deleted_at",
+		// Only the trash is indexed, the purge looks for old entries in it
+		Up: map[string][]string{
+			"postgres": {
+				"ALTER TABLE people ADD COLUMN IF NOT EXISTS deleted_at timestamptz",
+				"CREATE INDEX IF NOT EXISTS people_deleted_at_idx ON people (deleted_at) WHERE deleted_at IS NOT NULL",
+			},
+			"sqlite3": {
+				"ALTER TABLE people ADD COLUMN deleted_at datetime",
+				"CREATE INDEX people_deleted_at_idx ON people (deleted_at) WHERE deleted_at IS NOT NULL",
+			},
+		},
+		Down: map[string][]string{
+			// People still in the trash are removed, they were deleted
+			"postgres": {
+				"DELETE FROM people WHERE deleted_at IS NOT NULL",
+				"DROP INDEX IF EXISTS people_deleted_at_idx",
+				"ALTER TABLE people DROP COLUMN IF EXISTS deleted_at",
+			},
+			"sqlite3": {
+				"DELETE FROM people WHERE deleted_at IS NOT NULL",
+				"DROP INDEX people_deleted_at_idx",
+				"ALTER TABLE people DROP COLUMN deleted_at",
+			},
+		},
+	},
+	{
+		Version: 7,
+		Name:    "add_people_timestamps",
+		// Existing people were created and last updated now, as far as we know
+		Up: map[string][]string{
+			"postgres": {
+				"ALTER TABLE people ADD COLUMN IF NOT EXISTS created_at timestamptz NOT NULL DEFAULT now()",
+				"ALTER TABLE people ADD COLUMN IF NOT EXISTS updated_at timestamptz NOT NULL DEFAULT now()",
+				"CREATE INDEX IF NOT EXISTS people_updated_at_idx ON people (tenant, updated_at)",
+			},
+			// SQLite cannot add a column with a default of the current time.
+			// The backfill does not look like the times the driver writes, so
+			// migration 8 rewrites it.
+			"sqlite3": {
+				"ALTER TABLE people ADD COLUMN created_at datetime",
+				"ALTER TABLE people ADD COLUMN updated_at datetime",
+				"UPDATE people SET created_at = strftime('%Y-%m-%dT%H:%M:%fZ', 'now'), updated_at = strftime('%Y-%m-%dT%H:%M:%fZ', 'now')",
+				"CREATE INDEX people_updated_at_idx ON people (tenant, updated_at)",
+			},
+		},
+		Down: map[string][]string{
+			"postgres": {
+				"DROP INDEX IF EXISTS people_updated_at_idx",
+				"ALTER TABLE people DROP COLUMN IF EXISTS updated_at",
+				"ALTER TABLE people DROP COLUMN IF EXISTS created_at",
+			},
+			"sqlite3": {
+				"DROP INDEX people_updated_at_idx",
+				"ALTER TABLE people DROP COLUMN updated_at",
+				"ALTER TABLE people DROP COLUMN created_at",
+			},
+		},
+	},
+	{
+		Version: 8,
+		Name:    "rewrite_sqlite_people_timestamps",
+		// The SQLite driver writes times with time.Time.String, which in UTC
+		// looks like "2006-01-02 15:04:05.123 +0000 UTC" without trailing
+		// zeros, and compares them as text. The times migration 7 backfilled
+		// are rewritten the same way, postgres has real timestamps.
+		Up: map[string][]string{
+			"sqlite3": {
+				"UPDATE people SET created_at = rtrim(rtrim(strftime('%Y-%m-%d %H:%M:%f', created_at), '0'), '.') || ' +0000 UTC' WHERE created_at LIKE '____-__-__T%Z'",
+				"UPDATE people SET updated_at = rtrim(rtrim(strftime('%Y-%m-%d %H:%M:%f', updated_at), '0'), '.') || ' +0000 UTC' WHERE updated_at LIKE '____-__-__T%Z'",
+			},
+		},
+		// Either format is read back the same, so nothing is reverted
+		Down: map[string][]string{},
+	},
+}
+
+// migrationLockKey identifies the advisory lock held while migrating, so
+// replicas starting at the same time migrate one after the other
+const migrationLockKey = 7340521
+
+// migrationState is a migration together with what the database knows of it
+type migrationState struct {
+	migration
+	Checksum  string
+	Applied   bool
+	AppliedAt time.Time
+	// Modified is set when the migration changed after it was applied
+	Modified bool
+}
+
+// checksum identifies the statements of m that run on dialect
+func (m migration) checksum(dialect string) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%d %s\n", m.Version, m.Name)
+	for _, statement := range m.Up[dialect] {
+		fmt.Fprintf(hash, "up %s\n", statement)
+	}
+	for _, statement := range m.Down[dialect] {
+		fmt.Fprintf(hash, "down %s\n", statement)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// migrateUp applies all pending migrations
+func migrateUp(db *gorm.DB) error {
+	return withMigrationLock(db, func() error {
+		err := createMigrationsTable(db)
+		if err != nil {
+			return err
+		}
+		states, err := migrationStates(db)
+		if err != nil {
+			return err
+		}
+		for _, state := range states {
+			if state.Modified {
+				return fmt.Errorf("migration %d %s was changed after it was applied", state.Version, state.Name)
+			}
+		}
+
+		for _, state := range states {
+			if state.Applied {
+				continue
+			}
+			err = runMigration(db, state.migration, true)
+			if err != nil {
+				return fmt.Errorf("migration %d %s: %v", state.Version, state.Name, err)
+			}
+			logger.Info("applied migration", "version", state.Version, "name", state.Name)
+		}
+		return nil
+	})
+}
+
+// migrateDown reverts the latest applied migration
+func migrateDown(db *gorm.DB) error {
+	return withMigrationLock(db, func() error {
+		err := createMigrationsTable(db)
+		if err != nil {
+			return err
+		}
+		states, err := migrationStates(db)
+		if err != nil {
+			return err
+		}
+
+		for i := len(states) - 1; i >= 0; i-- {
+			if !states[i].Applied {
+				continue
+			}
+			err = runMigration(db, states[i].migration, false)
+			if err != nil {
+				return fmt.Errorf("reverting migration %d %s: %v", states[i].Version, states[i].Name, err)
+			}
+			logger.Info("reverted migration", "version", states[i].Version, "name", states[i].Name)
+			return nil
+		}
+		return errors.New("no migration is applied")
+	})
+}
+
+// createMigrationsTable creates the table recording the applied migrations
+func createMigrationsTable(db *gorm.DB) error {
+	return db.Exec("CREATE TABLE IF NOT EXISTS schema_migrations (version integer PRIMARY KEY, name varchar(255) NOT NULL, checksum varchar(64) NOT NULL, applied_at timestamp NOT NULL)").Error
+}
+
+// migrationStates returns every migration with its state in db
+func migrationStates(db *gorm.DB) ([]migrationState, error) {
+	rows, err := db.Raw("SELECT version, checksum, applied_at FROM schema_migrations").Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type applied struct {
+		checksum string
+		at       time.Time
+	}
+	appliedVersions := map[int]applied{}
+	for rows.Next() {
+		version, row := 0, applied{}
+		err = rows.Scan(&version, &row.checksum, &row.at)
+		if err != nil {
+			return nil, err
+		}
+		appliedVersions[version] = row
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	dialect := db.Dialect().GetName()
+	states := []migrationState{}
+	for _, m := range migrations {
+		state := migrationState{migration: m, Checksum: m.checksum(dialect)}
+		if row, ok := appliedVersions[m.Version]; ok {
+			state.Applied = true
+			state.AppliedAt = row.at
+			state.Modified = row.checksum != state.Checksum
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// runMigration applies or reverts m in a transaction together with its
+// row in schema_migrations
+func runMigration(db *gorm.DB, m migration, up bool) error {
+	dialect := db.Dialect().GetName()
+	statements := m.Down[dialect]
+	if up {
+		statements = m.Up[dialect]
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	for _, statement := range statements {
+		if err := tx.Exec(statement).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	var err error
+	if up {
+		err = tx.Exec("INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)",
+			m.Version, m.Name, m.checksum(dialect), time.Now().UTC()).Error
+	} else {
+		err = tx.Exec("DELETE FROM schema_migrations WHERE version = ?", m.Version).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// withMigrationLock runs migrate while no other process migrates db. On
+// postgres a session advisory lock is taken, SQLite allows a single writer
+// and every migration runs in its own transaction.
+func withMigrationLock(db *gorm.DB, migrate func() error) error {
+	if !isPostgres(db) {
+		return migrate()
+	}
+
+	ctx := context.Background()
+	conn, err := db.DB().Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey)
+	if err != nil {
+		return fmt.Errorf("taking migration lock: %v", err)
+	}
+	defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockKey)
+
+	return migrate()
+}
+
+// runMigrateCommand runs "migrate up", "migrate down" or "migrate status"
+// against the database of the given store backend
+func runMigrateCommand(backend string, args []string, out io.Writer) error {
+	if len(args) != 1 || (args[0] != "up" && args[0] != "down" && args[0] != "status") {
+		return errors.New("usage: migrate up|down|status")
+	}
+
+	db, err := openDb(backend)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "up":
+		return migrateUp(db)
+	case "down":
+		return migrateDown(db)
+	}
+
+	err = createMigrationsTable(db)
+	if err != nil {
+		return err
+	}
+	states, err := migrationStates(db)
+	if err != nil {
+		return err
+	}
+	writer := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(writer, "VERSION\tNAME\tSTATUS")
+	for _, state := range states {
+		status := "pending"
+		if state.Applied {
+			status = "applied " + state.AppliedAt.Format(time.RFC3339)
+		}
+		if state.Modified {
+			status += ", changed since"
+		}
+		fmt.Fprintln(writer, strings.Join([]string{strconv.Itoa(state.Version), state.Name, status}, "\t"))
+	}
+	return writer.Flush()
+}
+
+// openDb connects to the database of a backend without migrating it
+func openDb(backend string) (*gorm.DB, error) {
+	switch backend {
+	case "postgres":
+		return openPostgres()
+	case "sqlite":
+		return openSqlite(getEnv("SQLITE_PATH", "people.db"))
+	}
+	return nil, fmt.Errorf("STORE_BACKEND %s has no database to migrate", backend)
+}
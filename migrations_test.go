@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMigrationsAreOrdered(t *testing.T) {
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("migration %s has wrong version: got %v want %v", m.Name, m.Version, i+1)
+		}
+	}
+}
+
+func TestMigrateUpAndDown(t *testing.T) {
+	db, err := openSqlite(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	// A second run has nothing left to do
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+
+	states, err := migrationStates(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, state := range states {
+		if !state.Applied || state.Modified {
+			t.Errorf("migration %d has wrong state: got applied %v modified %v", state.Version, state.Applied, state.Modified)
+		}
+	}
+	if !db.HasTable("people") {
+		t.Fatal("migrateUp did not create the people table")
+	}
+
+	for range migrations {
+		if err := migrateDown(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if db.HasTable("people") {
+		t.Error("migrateDown did not drop the people table")
+	}
+	if err := migrateDown(db); err == nil {
+		t.Error("migrateDown succeeded without applied migrations")
+	}
+
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	sqliteStore := &dbStore{db}
+	if _, err := sqliteStore.createPerson(context.Background(), Person{Name:"Peter", PhoneNr:"24525345626"}); err != nil {
+		t.Errorf("createPerson failed on the migrated schema: %v", err)
+	}
+}
+
+func TestMigrateUpBackfillsTimestamps(t *testing.T) {
+	db, err := openSqlite(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// A person stored before migration 7 gets the time migration 7 runs,
+	// in the format migration 8 gives it
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := migrateDown(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Exec("INSERT INTO people (name, phone_nr, tenant) VALUES ('Peter', '24525345626', ?)", defaultTenant).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now().Add(-time.Second)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	migrated := time.Now()
+
+	stored := ""
+	if err := db.Raw("SELECT CAST(updated_at AS text) FROM people").Row().Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(stored, " +0000 UTC") {
+		t.Errorf("migration stored updated_at unlike the driver: %v", stored)
+	}
+
+	sqliteStore := &dbStore{db}
+	people, _, err := sqliteStore.getPeople(context.Background(), PeopleQuery{UpdatedSince:start})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(people) != 1 || people[0].UpdatedAt.Before(start) || people[0].UpdatedAt.After(migrated) || !people[0].CreatedAt.Equal(people[0].UpdatedAt) {
+		t.Fatalf("getPeople returned wrong backfilled people: %+v", people)
+	}
+
+	paul, err := sqliteStore.createPerson(context.Background(), Person{Name:"Paul", PhoneNr:"643265776357948984"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	people, _, err = sqliteStore.getPeople(context.Background(), PeopleQuery{UpdatedSince:migrated})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(people) != 1 || people[0].Id != paul.Id {
+		t.Errorf("getPeople returned wrong people updated since the migration: %+v", people)
+	}
+}
+
+func TestMigrateUpRejectsChangedMigration(t *testing.T) {
+	db, err := openSqlite(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec("UPDATE schema_migrations SET checksum = 'edited' WHERE version = 1").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	err = migrateUp(db)
+	expected := "migration 1 create_people was changed after it was applied"
+	if err == nil || err.Error() != expected {
+		t.Errorf("migrateUp returned wrong error: got %v want %v", err, expected)
+	}
+}
+
+func TestMigrateCommand(t *testing.T) {
+	t.Setenv("SQLITE_PATH", filepath.Join(t.TempDir(), "people.db"))
+
+	out := &bytes.Buffer{}
+	if err := runMigrateCommand("sqlite", []string{"status"}, out); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(out.String(), "pending") != len(migrations) {
+		t.Errorf("status returned wrong output: got %v", out.String())
+	}
+
+	if err := runMigrateCommand("sqlite", []string{"up"}, out); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if err := runMigrateCommand("sqlite", []string{"status"}, out); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(out.String(), "applied") != len(migrations) {
+		t.Errorf("status returned wrong output: got %v", out.String())
+	}
+
+	if err := runMigrateCommand("sqlite", []string{"sideways"}, out); err == nil {
+		t.Error("runMigrateCommand accepted an unknown command")
+	}
+	if err := runMigrateCommand("memory", []string{"up"}, out); err == nil {
+		t.Error("runMigrateCommand accepted a backend without database")
+	}
+}
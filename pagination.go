@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// pageCursor is the content of the opaque cursor parameter. It holds the
+// sort keys of the person at the border of the previous page.
+type pageCursor struct {
+	After  *Person `json:"after,omitempty"`
+	Before *Person `json:"before,omitempty"`
+}
+
+func encodeCursor(cursor pageCursor) string {
+	cursorBytes, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(cursorBytes)
+}
+
+func decodeCursor(value string) (pageCursor, error) {
+	cursor := pageCursor{}
+	if value == "" {
+		return cursor, nil
+	}
+
+	cursorBytes, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor, errors.New("is not a valid cursor")
+	}
+	err = json.Unmarshal(cursorBytes, &cursor)
+	if err != nil || (cursor.After != nil && cursor.Before != nil) {
+		return cursor, errors.New("is not a valid cursor")
+	}
+	return cursor, nil
+}
+
+// pageRequest holds the paging parameters of GET /people. Passing a cursor,
+// even an empty one to start at the beginning, selects cursor mode.
+type pageRequest struct {
+	cursorMode bool
+	limit      int
+	offset     int
+	cursor     pageCursor
+}
+
+func parsePageRequest(values url.Values) (pageRequest, []FieldError) {
+	page := pageRequest{limit: defaultPageSize}
+	fieldErrors := []FieldError{}
+
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxPageSize {
+			fieldErrors = append(fieldErrors, FieldError{"limit", "must be an integer between 1 and " + strconv.Itoa(maxPageSize)})
+		}
+		page.limit = n
+	}
+
+	if offset := values.Get("offset"); offset != "" {
+		n, err := strconv.Atoi(offset)
+		if err != nil || n < 0 {
+			fieldErrors = append(fieldErrors, FieldError{"offset", "must be a non-negative integer"})
+		}
+		page.offset = n
+	}
+
+	if cursor, ok := values["cursor"]; ok {
+		page.cursorMode = true
+		if _, ok := values["offset"]; ok {
+			fieldErrors = append(fieldErrors, FieldError{"cursor", "cannot be combined with offset"})
+		}
+		c, err := decodeCursor(cursor[0])
+		if err != nil {
+			fieldErrors = append(fieldErrors, FieldError{"cursor", err.Error()})
+		}
+		page.cursor = c
+	}
+
+	return page, fieldErrors
+}
+
+// storeQuery adds the page to query. In cursor mode one
+// more person than requested is loaded to find out if another page exists.
+func (page pageRequest) storeQuery(query PeopleQuery) PeopleQuery {
+	if !page.cursorMode {
+		query.Limit = page.limit
+		query.Offset = page.offset
+		return query
+	}
+	query.Limit = page.limit + 1
+	query.After = page.cursor.After
+	query.Before = page.cursor.Before
+	return query
+}
+
+// trim removes the extra person loaded in cursor mode and returns the
+// remaining people with the links to the neighbouring pages
+func (page pageRequest) trim(r *http.Request, people []Person, total int) ([]Person, map[string]string) {
+	links := map[string]string{}
+
+	if !page.cursorMode {
+		if page.offset+len(people) < total {
+			links["next"] = pageLink(r, "offset", strconv.Itoa(page.offset+page.limit), page.limit)
+		}
+		if page.offset > 0 {
+			prev := page.offset - page.limit
+			if prev < 0 {
+				prev = 0
+			}
+			links["prev"] = pageLink(r, "offset", strconv.Itoa(prev), page.limit)
+		}
+		return people, links
+	}
+
+	hasMore := len(people) > page.limit
+	if hasMore && page.cursor.Before != nil {
+		// Loaded backwards, so the extra person is the first one
+		people = people[1:]
+	} else if hasMore {
+		people = people[:page.limit]
+	}
+
+	if len(people) == 0 {
+		return people, links
+	}
+
+	first := cursorKey(people[0])
+	last := cursorKey(people[len(people)-1])
+	if hasMore || page.cursor.Before != nil {
+		links["next"] = pageLink(r, "cursor", encodeCursor(pageCursor{After: &last}), page.limit)
+	}
+	if (hasMore && page.cursor.Before != nil) || page.cursor.After != nil {
+		links["prev"] = pageLink(r, "cursor", encodeCursor(pageCursor{Before: &first}), page.limit)
+	}
+	return people, links
+}
+
+// cursorKey returns the fields of p that people can be sorted by
+func cursorKey(p Person) Person {
+	return Person{Id: p.Id, Name: p.Name, PhoneNr: p.PhoneNr}
+}
+
+func pageLink(r *http.Request, param string, value string, limit int) string {
+	values := r.URL.Query()
+	values.Del("offset")
+	values.Del("cursor")
+	values.Set(param, value)
+	values.Set("limit", strconv.Itoa(limit))
+	return r.URL.Path + "?" + values.Encode()
+}
+
+// writePageHeaders sets the Link and X-Total-Count headers of a page
+func writePageHeaders(w http.ResponseWriter, links map[string]string, total int) {
+	for _, rel := range []string{"prev", "next"} {
+		if link, ok := links[rel]; ok {
+			w.Header().Add("Link", "<"+link+">; rel=\""+rel+"\"")
+		}
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/gorilla/mux"
+)
+
+// Media types accepted by PatchPerson
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+// PatchPerson applies a JSON Merge Patch (RFC 7396) or a JSON Patch
+// (RFC 6902) to a stored person
+func PatchPerson(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	pId := params["id"]
+
+	id, err := strconv.Atoi(pId)
+	if err != nil {
+		writeInvalidId(w, r, pId)
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != mergePatchType && mediaType != jsonPatchType {
+		w.Header().Set("Accept-Patch", mergePatchType+", "+jsonPatchType)
+		writeError(w, r, http.StatusUnsupportedMediaType, "Content-Type must be "+mergePatchType+" or "+jsonPatchType)
+		return
+	}
+
+	patchBytes, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		writeInvalidBody(w, r, err)
+		return
+	}
+
+	person, err := store.getPerson(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, r, err, "Could not load person "+pId)
+		return
+	}
+
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && !matchesETag(parseETags(ifMatch), etag(person), false) {
+		writePreconditionFailed(w, r, person)
+		return
+	}
+
+	patched, err := applyPatch(person, mediaType, patchBytes)
+	if errors.Is(err, jsonpatch.ErrTestFailed) {
+		writeError(w, r, http.StatusConflict, "The patch does not apply to person "+pId+": "+err.Error())
+		return
+	}
+	if err != nil {
+		writeInvalidBody(w, r, err)
+		return
+	}
+
+	// The patched document must still be a valid person
+	result := Person{}
+	decoder := json.NewDecoder(bytes.NewReader(patched))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&result)
+	if err != nil {
+		writeProblem(w, newProblem(r, problemTypeInvalidBody, http.StatusUnprocessableEntity, "The patched person is invalid: "+err.Error()))
+		return
+	}
+
+	fieldErrors := validatePerson(result)
+	if result.Id != id {
+		fieldErrors = append(fieldErrors, FieldError{"id", "must not be changed"})
+	}
+	if len(fieldErrors) > 0 {
+		writeValidationErrors(w, r, http.StatusUnprocessableEntity, fieldErrors)
+		return
+	}
+
+	// The patch was made on the loaded version, concurrent changes must not be lost
+	result.Version = person.Version
+
+	result, err = store.updatePerson(r.Context(), result)
+	if err != nil {
+		writeStoreError(w, r, err, "Could not update person "+pId)
+		return
+	}
+
+	personBytes, err := json.Marshal(result)
+
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Could not encode person")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	setValidators(w, result)
+	w.Write(personBytes)
+}
+
+// applyPatch returns the JSON document of person with the patch applied
+func applyPatch(person Person, mediaType string, patchBytes []byte) ([]byte, error) {
+	personBytes, err := json.Marshal(person)
+	if err != nil {
+		return nil, err
+	}
+
+	if !json.Valid(patchBytes) {
+		return nil, errors.New("patch is not valid JSON")
+	}
+
+	if mediaType == mergePatchType {
+		if !bytes.HasPrefix(bytes.TrimSpace(patchBytes), []byte("{")) {
+			return nil, errors.New("merge patch must be a JSON object")
+		}
+		return jsonpatch.MergePatch(personBytes, patchBytes)
+	}
+
+	patch, err := jsonpatch.DecodePatch(patchBytes)
+	if err != nil {
+		return nil, err
+	}
+	return patch.Apply(personBytes)
+}
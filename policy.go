@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Scopes callers are granted, directly or through their roles
+const (
+	scopePeopleRead   = "people:read"
+	scopePeopleWrite  = "people:write"
+	scopePeopleDelete = "people:delete"
+	scopeAuditRead    = "audit:read"
+	// scopeTenantsAny lets callers without tenant choose one with the
+	// X-Tenant-ID header
+	scopeTenantsAny = "tenants:any"
+	// scopeAdmin grants every route
+	scopeAdmin = "admin"
+)
+
+// policy decides which callers may use a route. Routes maps a route name to
+// the scopes of which a caller needs one, Roles maps a role to the scopes it
+// grants.
+type policy struct {
+	Roles  map[string][]string `json:"roles"`
+	Routes map[string][]string `json:"routes"`
+}
+
+// defaultPolicy has readers, editors who may also create and change people,
+// auditors who may read people and their history, and admins who may do
+// anything
+func defaultPolicy() policy {
+	return policy{
+		Roles: map[string][]string{
+			"reader":  {scopePeopleRead},
+			"editor":  {scopePeopleRead, scopePeopleWrite},
+			"auditor": {scopePeopleRead, scopeAuditRead},
+			"admin":   {scopeAdmin},
+		},
+		Routes: map[string][]string{
+			"getPeople":    {scopePeopleRead},
+			"searchPeople": {scopePeopleRead},
+			"getPerson":    {scopePeopleRead},
+			"createPerson": {scopePeopleWrite},
+			"updatePerson": {scopePeopleWrite},
+			"patchPerson":  {scopePeopleWrite},
+			"deletePerson": {scopePeopleDelete},
+			// Whoever may delete a person may also undo it
+			"restorePerson": {scopePeopleDelete},
+			// The history holds values a change has removed
+			"getPersonHistory": {scopeAuditRead},
+			"getAudit":         {scopeAuditRead},
+		},
+	}
+}
+
+// accessPolicy is enforced by authorize, main reads it with loadPolicy
+var accessPolicy = defaultPolicy()
+
+// loadPolicy reads the policy file at path, whose roles and routes replace
+// those of the default policy with the same name. Without a path the default
+// policy is used. Every route of router but the public ones must have scopes
+// and every route named must exist, so a new route is not open by accident.
+func loadPolicy(router *mux.Router, path string) (policy, error) {
+	loaded := defaultPolicy()
+	if path != "" {
+		policyBytes, err := os.ReadFile(path)
+		if err != nil {
+			return policy{}, err
+		}
+		custom := policy{}
+		err = json.Unmarshal(policyBytes, &custom)
+		if err != nil {
+			return policy{}, fmt.Errorf("reading policy: %v", err)
+		}
+		for role, scopes := range custom.Roles {
+			loaded.Roles[role] = scopes
+		}
+		for route, scopes := range custom.Routes {
+			loaded.Routes[route] = scopes
+		}
+	}
+
+	for name, scopes := range loaded.Routes {
+		if router.Get(name) == nil {
+			return policy{}, fmt.Errorf("policy for unknown route %q", name)
+		}
+		if len(scopes) == 0 {
+			return policy{}, fmt.Errorf("policy grants route %s to nobody, give it at least one scope", name)
+		}
+	}
+	err := router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		name := route.GetName()
+		if _, ok := loaded.Routes[name]; !ok && !publicRoutes[name] {
+			return fmt.Errorf("policy has no scopes for route %q", name)
+		}
+		return nil
+	})
+	if err != nil {
+		return policy{}, err
+	}
+	return loaded, nil
+}
+
+// scopes returns the scopes of principal together with those of its roles
+func (p policy) scopes(principal Principal) map[string]bool {
+	scopes := map[string]bool{}
+	for _, scope := range principal.Scopes {
+		scopes[scope] = true
+	}
+	for _, role := range principal.Roles {
+		for _, scope := range p.Roles[role] {
+			scopes[scope] = true
+		}
+	}
+	return scopes
+}
+
+// grants returns whether principal has scope, which admins always have
+func (p policy) grants(principal Principal, scope string) bool {
+	granted := p.scopes(principal)
+	return granted[scopeAdmin] || granted[scope]
+}
+
+// allows returns whether principal may use route, and the reason if not
+func (p policy) allows(principal Principal, route string) (bool, string) {
+	required, ok := p.Routes[route]
+	if !ok {
+		required = []string{scopeAdmin}
+	}
+
+	granted := p.scopes(principal)
+	if granted[scopeAdmin] {
+		return true, ""
+	}
+	for _, scope := range required {
+		if granted[scope] {
+			return true, ""
+		}
+	}
+
+	needed := append([]string{}, required...)
+	sort.Strings(needed)
+	return false, fmt.Sprintf("Route %s needs scope %s", route, strings.Join(needed, " or "))
+}
+
+// authorize rejects authenticated callers the policy does not grant the
+// route with 403. Requests without principal, to public routes or with
+// authentication disabled, are passed on.
+func authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := principalFrom(r.Context())
+		route := mux.CurrentRoute(r)
+		if !ok || route == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		allowed, reason := accessPolicy.allows(principal, route.GetName())
+		if !allowed {
+			loggerFrom(r.Context()).Info("access denied", "subject", principal.Subject, "route", route.GetName())
+			writeError(w, r, http.StatusForbidden, reason)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
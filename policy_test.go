@@ -0,0 +1,168 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+)
+
+// staticAuthenticator authenticates every request as the same principal
+type staticAuthenticator Principal
+
+func (a staticAuthenticator) authenticate(r *http.Request) (Principal, error) {
+	return Principal(a), nil
+}
+
+func TestPolicyCoversEveryRouteAndRole(t *testing.T) {
+	principals := map[string]Principal{
+		"reader":       {Subject: "r", Roles: []string{"reader"}},
+		"editor":       {Subject: "e", Roles: []string{"editor"}},
+		"auditor":      {Subject: "t", Roles: []string{"auditor"}},
+		"admin":        {Subject: "a", Roles: []string{"admin"}},
+		"delete scope": {Subject: "d", Scopes: []string{scopePeopleDelete}},
+		"unknown role": {Subject: "u", Roles: []string{"guest"}},
+		"no roles":     {Subject: "n"},
+	}
+
+	routes := []struct {
+		name    string
+		method  string
+		path    string
+		allowed map[string]bool
+	}{
+		{"getPeople", "GET", "/people", map[string]bool{"reader": true, "editor": true, "auditor": true, "admin": true}},
+		{"createPerson", "POST", "/people", map[string]bool{"editor": true, "admin": true}},
+		{"searchPeople", "GET", "/people/search?q=pe", map[string]bool{"reader": true, "editor": true, "auditor": true, "admin": true}},
+		{"getPerson", "GET", "/people/3", map[string]bool{"reader": true, "editor": true, "auditor": true, "admin": true}},
+		{"updatePerson", "PUT", "/people/3", map[string]bool{"editor": true, "admin": true}},
+		{"patchPerson", "PATCH", "/people/3", map[string]bool{"editor": true, "admin": true}},
+		{"deletePerson", "DELETE", "/people/3", map[string]bool{"admin": true, "delete scope": true}},
+		{"restorePerson", "POST", "/people/3/restore", map[string]bool{"admin": true, "delete scope": true}},
+		{"getPersonHistory", "GET", "/people/3/history", map[string]bool{"auditor": true, "admin": true}},
+		{"getAudit", "GET", "/audit", map[string]bool{"auditor": true, "admin": true}},
+		{"healthz", "GET", "/healthz", map[string]bool{"reader": true, "editor": true, "auditor": true, "admin": true, "delete scope": true, "unknown role": true, "no roles": true}},
+		{"readyz", "GET", "/readyz", map[string]bool{"reader": true, "editor": true, "auditor": true, "admin": true, "delete scope": true, "unknown role": true, "no roles": true}},
+		{"metrics", "GET", "/metrics", map[string]bool{"reader": true, "editor": true, "auditor": true, "admin": true, "delete scope": true, "unknown role": true, "no roles": true}},
+	}
+
+	router := createRouter()
+	covered := map[string]bool{}
+	for _, route := range routes {
+		covered[route.name] = true
+	}
+	router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		if !covered[route.GetName()] {
+			t.Errorf("route %s is not covered", route.GetName())
+		}
+		return nil
+	})
+
+	for _, route := range routes {
+		for role, principal := range principals {
+			t.Run(route.name+"/"+role, func(t *testing.T) {
+				mockCtrl := gomock.NewController(t)
+				defer mockCtrl.Finish()
+
+				mockStore := NewMockStore(mockCtrl)
+				store = mockStore
+				mockStore.EXPECT().getPeople(gomock.Any(), gomock.Any()).Return(nil, 0, ErrUnavailable).AnyTimes()
+				mockStore.EXPECT().getPerson(gomock.Any(), gomock.Any()).Return(Person{}, ErrNotFound).AnyTimes()
+				mockStore.EXPECT().searchPeople(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, ErrUnavailable).AnyTimes()
+				mockStore.EXPECT().deletePerson(gomock.Any(), gomock.Any(), gomock.Any()).Return(ErrNotFound).AnyTimes()
+				mockStore.EXPECT().restorePerson(gomock.Any(), gomock.Any(), gomock.Any()).Return(Person{}, ErrNotFound).AnyTimes()
+				mockStore.EXPECT().getAudit(gomock.Any(), gomock.Any()).Return(nil, ErrUnavailable).AnyTimes()
+				mockStore.EXPECT().ping(gomock.Any()).Return(nil).AnyTimes()
+
+				useAuthenticator(t, staticAuthenticator(principal))
+
+				req, err := http.NewRequest(route.method, route.path, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				rr := httptest.NewRecorder()
+				router.ServeHTTP(rr, req)
+
+				if allowed := rr.Code != http.StatusForbidden; allowed != route.allowed[role] {
+					t.Errorf("wrong decision: got status %v, want allowed %v", rr.Code, route.allowed[role])
+				}
+			})
+		}
+	}
+}
+
+func TestAuthorizeReturnsForbiddenWithReason(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	store = NewMockStore(mockCtrl)
+
+	useAuthenticator(t, staticAuthenticator{Subject: "alice", Roles: []string{"reader"}})
+
+	req, err := http.NewRequest("DELETE", "/people/3", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	router := createRouter()
+
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusForbidden
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+
+	expectedBody := `{"type":"about:blank","title":"Forbidden","status":403,"detail":"Route deletePerson needs scope people:delete","instance":"/people/3","requestId":"test-request-id"}`
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	router := createRouter()
+
+	if _, err := loadPolicy(router, ""); err != nil {
+		t.Errorf("default policy does not match the routes: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "policy.json")
+	err := os.WriteFile(path, []byte(`{"roles": {"auditor": ["people:read"]}, "routes": {"deletePerson": ["people:delete", "people:write"]}}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadPolicy(router, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allowed, _ := loaded.allows(Principal{Roles: []string{"auditor"}}, "getPerson"); !allowed {
+		t.Errorf("role of policy file was not applied")
+	}
+	if allowed, _ := loaded.allows(Principal{Roles: []string{"editor"}}, "deletePerson"); !allowed {
+		t.Errorf("route of policy file was not applied")
+	}
+	if allowed, _ := loaded.allows(Principal{Roles: []string{"reader"}}, "getPeople"); !allowed {
+		t.Errorf("default role was lost")
+	}
+
+	for _, content := range []string{
+		`{"routes": {"listPeople": ["people:read"]}}`,
+		`{"routes": {"getPeople": []}}`,
+		`{"routes": `,
+	} {
+		err := os.WriteFile(path, []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadPolicy(router, path); err == nil {
+			t.Errorf("loadPolicy accepted %s", content)
+		}
+	}
+}
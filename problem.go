@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// Problem types used in error responses (RFC 7807)
+const (
+	problemTypeDefault     = "about:blank"
+	problemTypeInvalidBody = "/problems/invalid-body"
+	problemTypeInvalidId   = "/problems/invalid-id"
+	problemTypeValidation  = "/problems/validation-error"
+	problemTypeQuota       = "/problems/quota-exceeded"
+)
+
+// Problem is the body of every error response, sent as application/problem+json
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Errors   []FieldError `json:"errors,omitempty"`
+	// RequestId is the X-Request-ID the request is logged with
+	RequestId string `json:"requestId,omitempty"`
+}
+
+// FieldError describes why a single field of the request was rejected
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func newProblem(r *http.Request, problemType string, status int, detail string) Problem {
+	title := http.StatusText(status)
+	switch problemType {
+	case problemTypeInvalidBody:
+		title = "Invalid request body"
+	case problemTypeInvalidId:
+		title = "Invalid person id"
+	case problemTypeValidation:
+		title = "Validation failed"
+	case problemTypeQuota:
+		title = "Quota exceeded"
+	}
+
+	return Problem{
+		Type:      problemType,
+		Title:     title,
+		Status:    status,
+		Detail:    detail,
+		Instance:  r.URL.Path,
+		RequestId: requestID(r.Context()),
+	}
+}
+
+func writeProblem(w http.ResponseWriter, problem Problem) {
+	problemBytes, err := json.Marshal(problem)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(problem.Status)
+	w.Write(problemBytes)
+}
+
+// writeError sends a problem without further detail for the given status
+func writeError(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	writeProblem(w, newProblem(r, problemTypeDefault, status, detail))
+}
+
+func writeInvalidBody(w http.ResponseWriter, r *http.Request, err error) {
+	writeProblem(w, newProblem(r, problemTypeInvalidBody, http.StatusBadRequest, err.Error()))
+}
+
+func writeInvalidId(w http.ResponseWriter, r *http.Request, pId string) {
+	writeProblem(w, newProblem(r, problemTypeInvalidId, http.StatusBadRequest, "id must be an integer, got \""+pId+"\""))
+}
+
+func writeValidationErrors(w http.ResponseWriter, r *http.Request, status int, fieldErrors []FieldError) {
+	problem := newProblem(r, problemTypeValidation, status, "One or more fields are invalid")
+	problem.Errors = fieldErrors
+	writeProblem(w, problem)
+}
+
+// writeStoreError maps an error returned by the store to a problem response.
+// The detail is only used for unexpected errors.
+func writeStoreError(w http.ResponseWriter, r *http.Request, err error, detail string) {
+	subject := "Person"
+	if pId, ok := mux.Vars(r)["id"]; ok {
+		subject = "Person " + pId
+	}
+
+	logStoreError(r, err, detail)
+
+	var validationErr *ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		writeValidationErrors(w, r, http.StatusUnprocessableEntity, validationErr.Fields)
+	case errors.Is(err, ErrValidation):
+		writeError(w, r, http.StatusUnprocessableEntity, subject+" was rejected by the store")
+	case errors.Is(err, ErrNotFound):
+		writeError(w, r, http.StatusNotFound, subject+" does not exist")
+	case errors.Is(err, ErrVersionMismatch) && r.Header.Get("If-Match") != "":
+		writeError(w, r, http.StatusPreconditionFailed, subject+" was modified, the If-Match header does not match anymore")
+	case errors.Is(err, ErrVersionMismatch):
+		writeError(w, r, http.StatusConflict, subject+" was modified concurrently, load it again and retry")
+	case errors.Is(err, ErrNotDeleted):
+		writeError(w, r, http.StatusConflict, subject+" is not deleted")
+	case errors.Is(err, ErrConflict):
+		writeError(w, r, http.StatusConflict, subject+" conflicts with existing data")
+	case errors.Is(err, ErrQuotaExceeded):
+		writeProblem(w, newProblem(r, problemTypeQuota, http.StatusForbidden, "Tenant "+tenantFrom(r.Context())+" has reached its quota of people, delete some before creating more"))
+	case errors.Is(err, ErrUnavailable):
+		writeError(w, r, http.StatusServiceUnavailable, "The store is currently unavailable, try again later")
+	case errors.Is(err, context.DeadlineExceeded):
+		writeError(w, r, http.StatusGatewayTimeout, "The store did not answer in time")
+	case errors.Is(err, context.Canceled):
+		writeError(w, r, http.StatusServiceUnavailable, "The request was canceled before the store answered")
+	default:
+		writeError(w, r, http.StatusInternalServerError, detail)
+	}
+}
+
+// validatePerson returns an error for each missing or invalid field of p
+func validatePerson(p Person) []FieldError {
+	fieldErrors := []FieldError{}
+	if len(p.Name) == 0 {
+		fieldErrors = append(fieldErrors, FieldError{"name", "must not be empty"})
+	}
+	if len(p.PhoneNr) == 0 {
+		fieldErrors = append(fieldErrors, FieldError{"phoneNr", "must not be empty"})
+	}
+	return fieldErrors
+}
+
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusNotFound, "No route matches "+r.URL.Path)
+}
+
+func methodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusMethodNotAllowed, "Method "+r.Method+" is not allowed on "+r.URL.Path)
+}
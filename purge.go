@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// startPurge permanently removes the people deleted longer than retention
+// ago from s every interval until ctx is done. The returned channel is
+// closed once the purge stopped, so the store can be closed.
+func startPurge(ctx context.Context, s Store, retention time.Duration, interval time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				purgeOnce(ctx, s, retention, interval)
+			}
+		}
+	}()
+	return done
+}
+
+// purgeOnce runs a single purge, giving up once the next one is due
+func purgeOnce(ctx context.Context, s Store, retention time.Duration, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	before := time.Now().Add(-retention)
+	purged, err := s.purgeDeleted(ctx, before)
+	if err != nil {
+		logger.Error("purge failed", "error", err.Error(), "purged", purged)
+		return
+	}
+	if purged > 0 {
+		logger.Info("purged deleted people", "purged", purged, "deletedBefore", before.UTC().Format(time.RFC3339))
+	}
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+)
+
+// testSoftDelete checks that s keeps deleted people in the trash, hidden
+// from everything but getPeople with IncludeDeleted, until they are
+// restored or purged
+func testSoftDelete(t *testing.T, s Store) {
+	ctx := withTenant(context.Background(), "team-a")
+
+	peter, err := s.createPerson(ctx, Person{Name:"Peter", PhoneNr:"24525345626"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	paul, err := s.createPerson(ctx, Person{Name:"Paul", PhoneNr:"643265776357948984"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.restorePerson(ctx, peter.Id, 0); !errors.Is(err, ErrNotDeleted) {
+		t.Errorf("restorePerson of person not deleted returned wrong error: got %v want %v", err, ErrNotDeleted)
+	}
+	start := time.Now().Add(-time.Second)
+	if err := s.deletePerson(ctx, peter.Id, peter.Version); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.getPerson(ctx, peter.Id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("getPerson of deleted person returned wrong error: got %v want %v", err, ErrNotFound)
+	}
+	if _, err := s.updatePerson(ctx, Person{Id:peter.Id, Name:"Peter", PhoneNr:"1"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("updatePerson of deleted person returned wrong error: got %v want %v", err, ErrNotFound)
+	}
+	if err := s.deletePerson(ctx, peter.Id, 0); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deletePerson of deleted person returned wrong error: got %v want %v", err, ErrNotFound)
+	}
+	results, err := s.searchPeople(ctx, "Peter", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 0 {
+		t.Errorf("searchPeople found a deleted person: %+v", results)
+	}
+	people, total, err := s.getPeople(ctx, PeopleQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 1 || len(people) != 1 || people[0].Id != paul.Id {
+		t.Errorf("getPeople returned deleted person: %+v", people)
+	}
+	people, total, err = s.getPeople(ctx, PeopleQuery{IncludeDeleted:true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 || len(people) != 2 || people[0].Id != peter.Id || people[1].DeletedAt != nil {
+		t.Fatalf("getPeople with deleted people returned wrong people: %+v", people)
+	}
+	if deletedAt := people[0].DeletedAt; deletedAt == nil || deletedAt.Before(start) || deletedAt.After(time.Now()) {
+		t.Errorf("deleted person has wrong deletedAt: %v", deletedAt)
+	}
+
+	if _, err := s.restorePerson(ctx, peter.Id, peter.Version); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("restorePerson of old version returned wrong error: got %v want %v", err, ErrVersionMismatch)
+	}
+	if _, err := s.restorePerson(withTenant(context.Background(), "team-b"), peter.Id, 0); !errors.Is(err, ErrNotFound) {
+		t.Errorf("restorePerson of other tenant returned wrong error: got %v want %v", err, ErrNotFound)
+	}
+	restored, err := s.restorePerson(ctx, peter.Id, peter.Version+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Person{Id:peter.Id, Name:"Peter", PhoneNr:"24525345626", Version:peter.Version+2, Tenant:"team-a", CreatedAt:peter.CreatedAt, UpdatedAt:restored.UpdatedAt}
+	if restored != expected {
+		t.Errorf("restorePerson returned wrong person: got %+v want %+v", restored, expected)
+	}
+	if found, err := s.getPerson(ctx, peter.Id); err != nil || found != expected {
+		t.Errorf("getPerson of restored person returned wrong person: got %+v, %v want %+v", found, err, expected)
+	}
+
+	// The trash does not count against the quota, but restoring does
+	useQuotas(t, tenantQuotas{Tenants: map[string]int{"team-a": 2}})
+	if err := s.deletePerson(ctx, paul.Id, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.createPerson(ctx, Person{Name:"Alice", PhoneNr:"12343463462345243"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.restorePerson(ctx, paul.Id, 0); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("restorePerson beyond quota returned wrong error: got %v want %v", err, ErrQuotaExceeded)
+	}
+
+	if purged, err := s.purgeDeleted(ctx, time.Now().Add(-time.Hour)); err != nil || purged != 0 {
+		t.Errorf("purgeDeleted removed people deleted within retention: got %v, %v", purged, err)
+	}
+	if purged, err := s.purgeDeleted(context.Background(), time.Now().Add(time.Second)); err != nil || purged != 1 {
+		t.Errorf("purgeDeleted removed wrong number of people: got %v, %v want 1", purged, err)
+	}
+	if _, err := s.restorePerson(ctx, paul.Id, 0); !errors.Is(err, ErrNotFound) {
+		t.Errorf("restorePerson of purged person returned wrong error: got %v want %v", err, ErrNotFound)
+	}
+	if _, total, err := s.getPeople(ctx, PeopleQuery{IncludeDeleted:true}); err != nil || total != 2 {
+		t.Errorf("getPeople after purge returned wrong number of people: got %v, %v want 2", total, err)
+	}
+
+	history, err := s.getAudit(ctx, AuditQuery{PersonId:paul.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 3 || history[2].Op != auditPurge || history[2].Actor != purgeActor || history[2].Before == nil || history[2].After != nil {
+		t.Errorf("purge was not recorded: %+v", history)
+	}
+	history, err = s.getAudit(ctx, AuditQuery{PersonId:peter.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 3 || history[2].Op != auditRestore || history[2].After == nil || history[2].After.Name != expected.Name {
+		t.Errorf("restore was not recorded: %+v", history)
+	}
+}
+
+func TestMemoryStoreSoftDeletes(t *testing.T) {
+	testSoftDelete(t, NewMemoryStore())
+}
+
+func TestDbStoreSoftDeletes(t *testing.T) {
+	testSoftDelete(t, newSqliteTestStore(t))
+}
+
+func TestFileStoreSoftDeletes(t *testing.T) {
+	dir := t.TempDir()
+	fileStore, err := OpenFileStore(FileStoreOptions{Dir:dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testSoftDelete(t, fileStore)
+
+	ctx := withTenant(context.Background(), "team-a")
+	people, _, err := fileStore.getPeople(ctx, PeopleQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fileStore.deletePerson(ctx, people[0].Id, 0); err != nil {
+		t.Fatal(err)
+	}
+	expected, _, err := fileStore.getPeople(ctx, PeopleQuery{IncludeDeleted:true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reopenFileStore(t, fileStore, dir, func(fileStore *FileStore) {
+		recovered, total, err := fileStore.getPeople(ctx, PeopleQuery{IncludeDeleted:true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total != 2 || recovered[0].DeletedAt == nil || !recovered[0].DeletedAt.Equal(*expected[0].DeletedAt) || recovered[1].DeletedAt != nil {
+			t.Errorf("getPeople returned wrong people after reopening: %+v", recovered)
+		}
+		if _, err := fileStore.getPerson(ctx, recovered[0].Id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("getPerson of deleted person after reopening returned wrong error: got %v want %v", err, ErrNotFound)
+		}
+	})
+}
+
+func TestStartPurgeRemovesExpiredPeople(t *testing.T) {
+	memoryStore := NewMemoryStore()
+	ctx := withTenant(context.Background(), "team-a")
+	peter, err := memoryStore.createPerson(ctx, Person{Name:"Peter", PhoneNr:"24525345626"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := memoryStore.deletePerson(ctx, peter.Id, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	purgeCtx, cancel := context.WithCancel(context.Background())
+	stopped := startPurge(purgeCtx, memoryStore, time.Millisecond, 10*time.Millisecond)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, total, err := memoryStore.getPeople(ctx, PeopleQuery{IncludeDeleted:true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("deleted person was not purged")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("purge did not stop")
+	}
+}
+
+func TestRestorePersonReturnsPerson(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	store = NewMockStore(mockCtrl)
+	mockStore := store.(*MockStore)
+
+	mockStore.EXPECT().restorePerson(gomock.Any(), 3, 4).Return(Person{Id:3, Name:"Peter", PhoneNr:"24525345626", Version:5}, nil).Times(1)
+
+	req, err := http.NewRequest("POST", "/people/3/restore", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("If-Match", `"4"`)
+
+	rr := httptest.NewRecorder()
+	router := createRouter()
+
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusOK
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+
+	if etag := rr.Header().Get("ETag"); etag != `"5"` {
+		t.Errorf("handler returned wrong ETag: got %v want %v", etag, `"5"`)
+	}
+
+	expectedBody := `{"id":3,"name":"Peter","phoneNr":"24525345626"}`
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+}
+
+func TestRestorePersonReturnsConflict(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	store = NewMockStore(mockCtrl)
+	mockStore := store.(*MockStore)
+
+	mockStore.EXPECT().restorePerson(gomock.Any(), 3, 0).Return(Person{}, ErrNotDeleted).Times(1)
+
+	req, err := http.NewRequest("POST", "/people/3/restore", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	router := createRouter()
+
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusConflict
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+
+	expectedBody := `{"type":"about:blank","title":"Conflict","status":409,"detail":"Person 3 is not deleted","instance":"/people/3/restore","requestId":"test-request-id"}`
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+}
+
+func TestRestorePersonReturnsPreconditionFailed(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	store = NewMockStore(mockCtrl)
+	mockStore := store.(*MockStore)
+
+	// A weak tag never matches
+	mockStore.EXPECT().restorePerson(gomock.Any(), 3, -1).Return(Person{}, ErrVersionMismatch).Times(1)
+
+	req, err := http.NewRequest("POST", "/people/3/restore", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("If-Match", `W/"4"`)
+
+	rr := httptest.NewRecorder()
+	router := createRouter()
+
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusPreconditionFailed
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+
+	expectedBody := `{"type":"about:blank","title":"Precondition Failed","status":412,"detail":"Person 3 was modified, the If-Match header does not match anymore","instance":"/people/3/restore","requestId":"test-request-id"}`
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+}
+
+func TestGetPeopleIncludesDeleted(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	store = NewMockStore(mockCtrl)
+	mockStore := store.(*MockStore)
+
+	deletedAt := time.Date(2024, 1, 31, 8, 0, 0, 0, time.UTC)
+	pList := []Person{
+		{Id:1, Name:"Peter", PhoneNr:"24525345626", DeletedAt:&deletedAt},
+		{Id:2, Name:"Paul", PhoneNr:"643265776357948984"},
+	}
+	mockStore.EXPECT().getPeople(gomock.Any(), PeopleQuery{IncludeDeleted:true, Limit:100}).Return(pList, 2, nil).Times(1)
+
+	req, err := http.NewRequest("GET", "/people?include_deleted=true", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	router := createRouter()
+
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusOK
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+
+	expectedBody := `[{"id":1,"name":"Peter","phoneNr":"24525345626","deletedAt":"2024-01-31T08:00:00Z"},{"id":2,"name":"Paul","phoneNr":"643265776357948984"}]`
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+}
+
+func TestGetPeopleRejectsInvalidIncludeDeleted(t *testing.T) {
+	req, err := http.NewRequest("GET", "/people?include_deleted=yes", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	router := createRouter()
+
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusBadRequest
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+
+	expectedBody := `{"type":"/problems/validation-error","title":"Validation failed","status":400,"detail":"One or more fields are invalid","instance":"/people","errors":[{"field":"include_deleted","message":"must be true or false"}],"requestId":"test-request-id"}`
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Columns of the people table by the JSON name of the field
+var sortColumns = map[string]string{
+	"id":      "id",
+	"name":    "name",
+	"phoneNr": "phone_nr",
+}
+
+// parsePeopleQuery reads the filter and sort parameters of GET /people
+func parsePeopleQuery(values url.Values) (PeopleQuery, []FieldError) {
+	query := PeopleQuery{
+		Name:          values.Get("name"),
+		NamePrefix:    values.Get("name_prefix"),
+		PhoneNrPrefix: values.Get("phoneNr_prefix"),
+	}
+	fieldErrors := []FieldError{}
+
+	if value := values.Get("updated_since"); value != "" {
+		updatedSince, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			fieldErrors = append(fieldErrors, FieldError{"updated_since", "must be an RFC 3339 timestamp like 2024-01-31T08:00:00Z"})
+		}
+		query.UpdatedSince = updatedSince.UTC()
+	}
+
+	switch values.Get("include_deleted") {
+	case "", "false":
+	case "true":
+		query.IncludeDeleted = true
+	default:
+		fieldErrors = append(fieldErrors, FieldError{"include_deleted", "must be true or false"})
+	}
+
+	if sortValue := values.Get("sort"); sortValue != "" {
+		seen := map[string]bool{}
+		for _, field := range strings.Split(sortValue, ",") {
+			sortField := SortField{Field: strings.TrimSpace(field)}
+			if strings.HasPrefix(sortField.Field, "-") {
+				sortField.Field = sortField.Field[1:]
+				sortField.Descending = true
+			}
+			if _, ok := sortColumns[sortField.Field]; !ok {
+				fieldErrors = append(fieldErrors, FieldError{"sort", "cannot sort by \"" + field + "\""})
+				continue
+			}
+			if seen[sortField.Field] {
+				fieldErrors = append(fieldErrors, FieldError{"sort", "contains \"" + sortField.Field + "\" more than once"})
+				continue
+			}
+			seen[sortField.Field] = true
+			query.Sort = append(query.Sort, sortField)
+		}
+	}
+
+	return query, fieldErrors
+}
+
+// order returns the sort order of the query, which always ends with the id
+// so that people with equal sort keys keep a stable order
+func (query PeopleQuery) order() []SortField {
+	order := []SortField{}
+	for _, sortField := range query.Sort {
+		order = append(order, sortField)
+		if sortField.Field == "id" {
+			return order
+		}
+	}
+	return append(order, SortField{Field: "id"})
+}
+
+// matches reports whether p passes all filters of the query
+func (query PeopleQuery) matches(p Person) bool {
+	if p.DeletedAt != nil && !query.IncludeDeleted {
+		return false
+	}
+	if query.Name != "" && p.Name != query.Name {
+		return false
+	}
+	if query.NamePrefix != "" && !strings.HasPrefix(p.Name, query.NamePrefix) {
+		return false
+	}
+	if query.PhoneNrPrefix != "" && !strings.HasPrefix(p.PhoneNr, query.PhoneNrPrefix) {
+		return false
+	}
+	if p.UpdatedAt.Before(query.UpdatedSince) {
+		return false
+	}
+	return true
+}
+
+// comparePeople compares a and b by order and returns -1, 0 or +1
+func comparePeople(a Person, b Person, order []SortField) int {
+	for _, sortField := range order {
+		result := 0
+		switch sortField.Field {
+		case "id":
+			if a.Id < b.Id {
+				result = -1
+			} else if a.Id > b.Id {
+				result = 1
+			}
+		case "name":
+			result = strings.Compare(a.Name, b.Name)
+		case "phoneNr":
+			result = strings.Compare(a.PhoneNr, b.PhoneNr)
+		}
+		if sortField.Descending {
+			result = -result
+		}
+		if result != 0 {
+			return result
+		}
+	}
+	return 0
+}
+
+// queryPeople evaluates query against people held in memory and returns the
+// page and the number of matching people
+func queryPeople(people []Person, query PeopleQuery) ([]Person, int) {
+	order := query.order()
+
+	matches := []Person{}
+	for _, person := range people {
+		if query.matches(person) {
+			matches = append(matches, person)
+		}
+	}
+	total := len(matches)
+
+	sort.Slice(matches, func(i, j int) bool {
+		return comparePeople(matches[i], matches[j], order) < 0
+	})
+
+	page := []Person{}
+	for _, person := range matches {
+		if query.After != nil && comparePeople(person, *query.After, order) <= 0 {
+			continue
+		}
+		if query.Before != nil && comparePeople(person, *query.Before, order) >= 0 {
+			continue
+		}
+		page = append(page, person)
+	}
+
+	if query.Before != nil {
+		// Take the page directly in front of the cursor
+		if query.Limit > 0 && len(page) > query.Limit {
+			page = page[len(page)-query.Limit:]
+		}
+		return page, total
+	}
+
+	if query.Offset >= len(page) {
+		return []Person{}, total
+	}
+	page = page[query.Offset:]
+	if query.Limit > 0 && len(page) > query.Limit {
+		page = page[:query.Limit]
+	}
+	return page, total
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+
+	// Minimum name similarity of a match, the default threshold of pg_trgm
+	similarityThreshold = 0.3
+)
+
+// SearchResult is a person found by a search together with its relevance
+// between 0 and 1
+type SearchResult struct {
+	Person
+	Score float64 `json:"score"`
+}
+
+func SearchPeople(w http.ResponseWriter, r *http.Request) {
+	fieldErrors := []FieldError{}
+
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		fieldErrors = append(fieldErrors, FieldError{"q", "must not be empty"})
+	}
+
+	limit := defaultSearchLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxSearchLimit {
+			fieldErrors = append(fieldErrors, FieldError{"limit", "must be an integer between 1 and " + strconv.Itoa(maxSearchLimit)})
+		}
+		limit = n
+	}
+
+	if len(fieldErrors) > 0 {
+		writeValidationErrors(w, r, http.StatusBadRequest, fieldErrors)
+		return
+	}
+
+	results, err := store.searchPeople(r.Context(), q, limit)
+	if err != nil {
+		writeStoreError(w, r, err, "Could not search people")
+		return
+	}
+
+	if len(results) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	resultBytes, err := json.Marshal(results)
+
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Could not encode search results")
+		return
+	}
+	w.Write(resultBytes)
+}
+
+// trigrams returns the trigrams of the words in s the way pg_trgm builds
+// them: lower case, each word padded with two spaces in front and one behind
+func trigrams(s string) map[string]bool {
+	result := map[string]bool{}
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		padded := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			result[string(padded[i:i+3])] = true
+		}
+	}
+	return result
+}
+
+// similarity returns the share of trigrams a and b have in common
+func similarity(a map[string]bool, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	common := 0
+	for trigram := range a {
+		if b[trigram] {
+			common++
+		}
+	}
+	return float64(common) / float64(len(a)+len(b)-common)
+}
+
+// nameScore rates how well name matches q, either as a whole or by the
+// best matching word, so a single remembered name still ranks high
+func nameScore(q string, name string) float64 {
+	qTrigrams := trigrams(q)
+	score := similarity(qTrigrams, trigrams(name))
+	for _, word := range strings.Fields(name) {
+		if wordScore := similarity(qTrigrams, trigrams(word)); wordScore > score {
+			score = wordScore
+		}
+	}
+	return score
+}
+
+// digits returns the digits of s, dropping spaces, dashes and the like
+func digits(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+// phoneScore rates a phone number containing the digits of q. Longer
+// sequences cover more of the number and rank higher.
+func phoneScore(qDigits string, phoneNr string) float64 {
+	phoneDigits := digits(phoneNr)
+	if qDigits == "" || !strings.Contains(phoneDigits, qDigits) {
+		return 0
+	}
+	return 0.5 + 0.5*float64(len(qDigits))/float64(len(phoneDigits))
+}
+
+// scorePerson returns the relevance of p for q, 0 if p does not match
+func scorePerson(q string, p Person) float64 {
+	score := nameScore(q, p.Name)
+	if score < similarityThreshold {
+		score = 0
+	}
+	if phone := phoneScore(digits(q), p.PhoneNr); phone > score {
+		score = phone
+	}
+	return score
+}
+
+// sortResults orders results by descending score and then by id
+func sortResults(results []SearchResult, limit int) []SearchResult {
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Id < results[j].Id
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// searchIndex maps the trigrams of names and phone digits to the ids of
+// the people containing them, so a search only scores likely candidates
+type searchIndex struct {
+	names  map[string]map[int]bool
+	phones map[string]map[int]bool
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{make(map[string]map[int]bool), make(map[string]map[int]bool)}
+}
+
+func (index *searchIndex) add(p Person) {
+	for trigram := range trigrams(p.Name) {
+		addToIndex(index.names, trigram, p.Id)
+	}
+	for trigram := range digitTrigrams(digits(p.PhoneNr)) {
+		addToIndex(index.phones, trigram, p.Id)
+	}
+}
+
+func (index *searchIndex) remove(p Person) {
+	for trigram := range trigrams(p.Name) {
+		removeFromIndex(index.names, trigram, p.Id)
+	}
+	for trigram := range digitTrigrams(digits(p.PhoneNr)) {
+		removeFromIndex(index.phones, trigram, p.Id)
+	}
+}
+
+// candidates returns the ids of the people that can match q. Digit
+// sequences shorter than a trigram cannot use the index and return all ids.
+func (index *searchIndex) candidates(q string, all func() []int) []int {
+	ids := map[int]bool{}
+	for trigram := range trigrams(q) {
+		for id := range index.names[trigram] {
+			ids[id] = true
+		}
+	}
+
+	qDigits := digits(q)
+	if len(qDigits) > 0 && len(qDigits) < 3 {
+		return all()
+	}
+	if len(qDigits) >= 3 {
+		var phoneIds map[int]bool
+		for trigram := range digitTrigrams(qDigits) {
+			next := map[int]bool{}
+			for id := range index.phones[trigram] {
+				if phoneIds == nil || phoneIds[id] {
+					next[id] = true
+				}
+			}
+			phoneIds = next
+		}
+		for id := range phoneIds {
+			ids[id] = true
+		}
+	}
+
+	result := []int{}
+	for id := range ids {
+		result = append(result, id)
+	}
+	return result
+}
+
+func digitTrigrams(s string) map[string]bool {
+	result := map[string]bool{}
+	for i := 0; i+3 <= len(s); i++ {
+		result[s[i:i+3]] = true
+	}
+	return result
+}
+
+func addToIndex(index map[string]map[int]bool, key string, id int) {
+	if index[key] == nil {
+		index[key] = make(map[int]bool)
+	}
+	index[key][id] = true
+}
+
+func removeFromIndex(index map[string]map[int]bool, key string, id int) {
+	delete(index[key], id)
+	if len(index[key]) == 0 {
+		delete(index, key)
+	}
+}
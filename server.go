@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// newServer returns the server for handler with the timeouts configured by
+// the environment. The write timeout has to be longer than any route timeout.
+func newServer(handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              getEnv("LISTEN_ADDR", ":8080"),
+		Handler:           handler,
+		ReadHeaderTimeout: getEnvDuration("HTTP_READ_HEADER_TIMEOUT", 5*time.Second),
+		ReadTimeout:       getEnvDuration("HTTP_READ_TIMEOUT", 15*time.Second),
+		WriteTimeout:      getEnvDuration("HTTP_WRITE_TIMEOUT", 60*time.Second),
+		IdleTimeout:       getEnvDuration("HTTP_IDLE_TIMEOUT", 120*time.Second),
+	}
+}
+
+// serve handles connections on listener until ctx is done. Readiness then
+// fails for drainDelay, so the load balancer stops sending requests, before
+// the server stops accepting connections and waits up to shutdownTimeout
+// for running requests to finish. Requests still running then are closed.
+func serve(ctx context.Context, server *http.Server, listener net.Listener, drainDelay time.Duration, shutdownTimeout time.Duration) error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	logger.Info("shutting down", "drainDelay", drainDelay.String(), "timeout", shutdownTimeout.String())
+	draining.Store(true)
+	time.Sleep(drainDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err := server.Shutdown(shutdownCtx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		server.Close()
+		return fmt.Errorf("requests still running after %v, closed them", shutdownTimeout)
+	}
+	return err
+}
+
+// retryPolicy decides how long connecting to a database that is not ready
+// yet is retried, the wait doubles after every failure up to MaxWait
+type retryPolicy struct {
+	Timeout     time.Duration
+	InitialWait time.Duration
+	MaxWait     time.Duration
+}
+
+// connectWithRetry calls connect until it succeeds or the policy gives up.
+// The database may start later than the service, for example during a
+// rollout.
+func connectWithRetry(policy retryPolicy, connect func() (*gorm.DB, error)) (*gorm.DB, error) {
+	deadline := time.Now().Add(policy.Timeout)
+	wait := policy.InitialWait
+	for {
+		db, err := connect()
+		if err == nil {
+			return db, nil
+		}
+		if time.Now().Add(wait).After(deadline) {
+			return nil, fmt.Errorf("database not ready after %v: %v", policy.Timeout, err)
+		}
+
+		logger.Warn("database not ready", "retryIn", wait.String(), "error", err.Error())
+		time.Sleep(wait)
+		wait *= 2
+		if wait > policy.MaxWait {
+			wait = policy.MaxWait
+		}
+	}
+}
+
+// getEnvDuration reads a duration like "30s" from the environment
+func getEnvDuration(name string, defaultVal time.Duration) time.Duration {
+	value := getEnv(name, defaultVal.String())
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		panic(fmt.Sprintf("invalid %s: %v", name, err))
+	}
+	return duration
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+func TestServeDrainsRunningRequests(t *testing.T) {
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("done"))
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(ctx, &http.Server{Handler:handler}, listener, 0, 5*time.Second)
+	}()
+
+	responses := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String() + "/")
+		if err != nil {
+			responses <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		responses <- string(body)
+	}()
+
+	// Shut down while the request is running
+	<-started
+	cancel()
+
+	if body := <-responses; body != "done" {
+		t.Errorf("running request was not finished: got %v want %v", body, "done")
+	}
+	if err := <-served; err != nil {
+		t.Errorf("serve returned an error: %v", err)
+	}
+	if _, err := net.Dial("tcp", listener.Addr().String()); err == nil {
+		t.Error("server still accepts connections after the shutdown")
+	}
+	if !draining.Load() {
+		t.Error("serve did not fail readiness during the shutdown")
+	}
+	draining.Store(false)
+}
+
+func TestServeClosesRequestsAfterTimeout(t *testing.T) {
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+			w.Write([]byte("done"))
+		}
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(ctx, &http.Server{Handler:handler}, listener, 0, 50*time.Millisecond)
+	}()
+
+	responses := make(chan error, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String() + "/")
+		if err == nil {
+			resp.Body.Close()
+		}
+		responses <- err
+	}()
+
+	<-started
+	cancel()
+
+	if err := <-served; err == nil {
+		t.Error("serve returned no error with a request still running")
+	}
+	select {
+	case err := <-responses:
+		if err == nil {
+			t.Error("request still running after the timeout was answered")
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("request still running after the timeout was not closed")
+	}
+	draining.Store(false)
+}
+
+func TestConnectWithRetry(t *testing.T) {
+	policy := retryPolicy{Timeout:time.Second, InitialWait:time.Millisecond, MaxWait:2 * time.Millisecond}
+
+	attempts := 0
+	db, err := connectWithRetry(policy, func() (*gorm.DB, error) {
+		attempts++
+		if attempts < 3 {
+			return nil, errors.New("connection refused")
+		}
+		return openSqlite(":memory:")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if attempts != 3 {
+		t.Errorf("connectWithRetry made wrong number of attempts: got %v want %v", attempts, 3)
+	}
+
+	policy.Timeout = 10 * time.Millisecond
+	_, err = connectWithRetry(policy, func() (*gorm.DB, error) {
+		return nil, errors.New("connection refused")
+	})
+	if err == nil {
+		t.Error("connectWithRetry did not give up")
+	}
+}
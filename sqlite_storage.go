@@ -0,0 +1,48 @@
+package main
+
+import (
+	"database/sql"
+
+	"github.com/jinzhu/gorm"
+	_ "modernc.org/sqlite"
+)
+
+// SetupSqliteStorage opens the SQLite database in SQLITE_PATH with the
+// dbStore. The driver is written in Go, so no cgo toolchain is needed.
+func SetupSqliteStorage() Store {
+	path := getEnv("SQLITE_PATH", "people.db")
+
+	db, err := openSqlite(path)
+	if err != nil {
+		panic(err)
+	}
+
+	err = prepareDb(db)
+	if err != nil {
+		panic(err)
+	}
+
+	return &dbStore{db}
+}
+
+// openSqlite opens the database at path, ":memory:" opens a private
+// database that is gone once it is closed
+func openSqlite(path string) (*gorm.DB, error) {
+	dsn := "file:" + path + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)"
+	sqlDB, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite allows a single writer, more connections only wait for the lock.
+	// A memory database also exists once per connection.
+	sqlDB.SetMaxOpenConns(1)
+
+	// gorm ships the sqlite3 dialect, only the driver is replaced
+	db, err := gorm.Open("sqlite3", sqlDB)
+	if err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
+	return db, nil
+}
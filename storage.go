@@ -1,9 +1,113 @@
 package main
 
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Errors returned by every Store implementation. Implementations may wrap
+// them with more detail, callers should compare with errors.Is.
+var (
+	ErrNotFound    = errors.New("person not found")
+	ErrConflict    = errors.New("person conflicts with existing data")
+	ErrValidation  = errors.New("person is invalid")
+	ErrUnavailable = errors.New("store unavailable")
+
+	// ErrVersionMismatch is returned by a conditional change of a person
+	// that was modified since the expected version
+	ErrVersionMismatch = errors.New("person was modified concurrently")
+
+	// ErrQuotaExceeded is returned when a tenant may not store more people
+	ErrQuotaExceeded = errors.New("tenant quota exceeded")
+
+	// ErrNotDeleted is returned when restoring a person that is not deleted
+	ErrNotDeleted = errors.New("person is not deleted")
+)
+
+// ValidationError is returned when a store rejects the values of a person
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := []string{}
+	for _, field := range e.Fields {
+		messages = append(messages, field.Field+" "+field.Message)
+	}
+	return ErrValidation.Error() + ": " + strings.Join(messages, ", ")
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// checkPerson returns a ValidationError if p is not fit to be stored
+func checkPerson(p Person) error {
+	if fieldErrors := validatePerson(p); len(fieldErrors) > 0 {
+		return &ValidationError{fieldErrors}
+	}
+	return nil
+}
+
+// PeopleQuery selects a page of the people matching all set filters. People
+// are ordered by Sort followed by the id. Either Offset or one of the
+// After/Before cursors is used, a Limit of 0 means no limit. Deleted people
+// are only selected with IncludeDeleted. UpdatedSince selects the people
+// updated at or after it, a zero UpdatedSince all of them.
+type PeopleQuery struct {
+	Name           string
+	NamePrefix     string
+	PhoneNrPrefix  string
+	IncludeDeleted bool
+	UpdatedSince   time.Time
+	Sort           []SortField
+	Limit          int
+	Offset         int
+	After          *Person
+	Before         *Person
+}
+
+// SortField is one key of the sort order, Field is the JSON name of a Person field
+type SortField struct {
+	Field      string
+	Descending bool
+}
+
+// Store methods take the context of the request and give up with the
+// error of the context once it is canceled or its deadline passed. They
+// only see and change the people of the tenant of the context, people of
+// other tenants do not exist for them. Deleted people are kept until they
+// are purged, but only getPeople, restorePerson and purgeDeleted see them.
 type Store interface {
-	getPeople() ([]Person, error)
-	getPerson(id int) (Person, error)
-	createPerson(p Person) error
-	updatePerson(p Person) error
-	deletePerson(id int) error
-}
\ No newline at end of file
+	// getPeople returns the requested page and the number of matching people
+	getPeople(ctx context.Context, query PeopleQuery) ([]Person, int, error)
+	getPerson(ctx context.Context, id int) (Person, error)
+	createPerson(ctx context.Context, p Person) (Person, error)
+	// updatePerson, deletePerson and restorePerson only succeed if the person
+	// still has the given version, a version of 0 changes the person
+	// unconditionally
+	updatePerson(ctx context.Context, p Person) (Person, error)
+	// deletePerson marks the person deleted
+	deletePerson(ctx context.Context, id int, version int) error
+	// restorePerson undoes the delete of a person not purged yet
+	restorePerson(ctx context.Context, id int, version int) (Person, error)
+	// purgeDeleted permanently removes the people of every tenant deleted
+	// before the given time and returns how many it removed
+	purgeDeleted(ctx context.Context, before time.Time) (int, error)
+	// searchPeople returns the people best matching q by name or phone number
+	searchPeople(ctx context.Context, q string, limit int) ([]SearchResult, error)
+	// getAudit returns the recorded changes selected by query. Every change,
+	// including restores and purges, is recorded together with the change
+	// itself.
+	getAudit(ctx context.Context, query AuditQuery) ([]AuditEntry, error)
+	// ping reports whether the store can serve requests
+	ping(ctx context.Context) error
+}
+
+// storeTime returns the current time as the stores keep it, in UTC with
+// the precision of postgres
+func storeTime() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
@@ -5,8 +5,10 @@
 package main
 
 import (
+	context "context"
 	gomock "github.com/golang/mock/gomock"
 	reflect "reflect"
+	time "time"
 )
 
 // MockStore is a mock of Store interface
@@ -33,63 +35,130 @@ func (m *MockStore) EXPECT() *MockStoreMockRecorder {
 }
 
 // getPeople mocks base method
-func (m *MockStore) getPeople() ([]Person, error) {
-	ret := m.ctrl.Call(m, "getPeople")
+func (m *MockStore) getPeople(ctx context.Context, query PeopleQuery) ([]Person, int, error) {
+	ret := m.ctrl.Call(m, "getPeople", ctx, query)
 	ret0, _ := ret[0].([]Person)
-	ret1, _ := ret[1].(error)
-	return ret0, ret1
+	ret1, _ := ret[1].(int)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
 }
 
 // getPeople indicates an expected call of getPeople
-func (mr *MockStoreMockRecorder) getPeople() *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "getPeople", reflect.TypeOf((*MockStore)(nil).getPeople))
+func (mr *MockStoreMockRecorder) getPeople(ctx, query interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "getPeople", reflect.TypeOf((*MockStore)(nil).getPeople), ctx, query)
 }
 
 // getPerson mocks base method
-func (m *MockStore) getPerson(id int) (Person, error) {
-	ret := m.ctrl.Call(m, "getPerson", id)
+func (m *MockStore) getPerson(ctx context.Context, id int) (Person, error) {
+	ret := m.ctrl.Call(m, "getPerson", ctx, id)
 	ret0, _ := ret[0].(Person)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // getPerson indicates an expected call of getPerson
-func (mr *MockStoreMockRecorder) getPerson(id interface{}) *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "getPerson", reflect.TypeOf((*MockStore)(nil).getPerson), id)
+func (mr *MockStoreMockRecorder) getPerson(ctx, id interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "getPerson", reflect.TypeOf((*MockStore)(nil).getPerson), ctx, id)
 }
 
 // createPerson mocks base method
-func (m *MockStore) createPerson(p Person) error {
-	ret := m.ctrl.Call(m, "createPerson", p)
-	ret0, _ := ret[0].(error)
-	return ret0
+func (m *MockStore) createPerson(ctx context.Context, p Person) (Person, error) {
+	ret := m.ctrl.Call(m, "createPerson", ctx, p)
+	ret0, _ := ret[0].(Person)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
 // createPerson indicates an expected call of createPerson
-func (mr *MockStoreMockRecorder) createPerson(p interface{}) *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "createPerson", reflect.TypeOf((*MockStore)(nil).createPerson), p)
+func (mr *MockStoreMockRecorder) createPerson(ctx, p interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "createPerson", reflect.TypeOf((*MockStore)(nil).createPerson), ctx, p)
 }
 
 // updatePerson mocks base method
-func (m *MockStore) updatePerson(p Person) error {
-	ret := m.ctrl.Call(m, "updatePerson", p)
-	ret0, _ := ret[0].(error)
-	return ret0
+func (m *MockStore) updatePerson(ctx context.Context, p Person) (Person, error) {
+	ret := m.ctrl.Call(m, "updatePerson", ctx, p)
+	ret0, _ := ret[0].(Person)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
 // updatePerson indicates an expected call of updatePerson
-func (mr *MockStoreMockRecorder) updatePerson(p interface{}) *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "updatePerson", reflect.TypeOf((*MockStore)(nil).updatePerson), p)
+func (mr *MockStoreMockRecorder) updatePerson(ctx, p interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "updatePerson", reflect.TypeOf((*MockStore)(nil).updatePerson), ctx, p)
 }
 
 // deletePerson mocks base method
-func (m *MockStore) deletePerson(id int) error {
-	ret := m.ctrl.Call(m, "deletePerson", id)
+func (m *MockStore) deletePerson(ctx context.Context, id, version int) error {
+	ret := m.ctrl.Call(m, "deletePerson", ctx, id, version)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // deletePerson indicates an expected call of deletePerson
-func (mr *MockStoreMockRecorder) deletePerson(id interface{}) *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "deletePerson", reflect.TypeOf((*MockStore)(nil).deletePerson), id)
+func (mr *MockStoreMockRecorder) deletePerson(ctx, id, version interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "deletePerson", reflect.TypeOf((*MockStore)(nil).deletePerson), ctx, id, version)
+}
+
+// restorePerson mocks base method
+func (m *MockStore) restorePerson(ctx context.Context, id, version int) (Person, error) {
+	ret := m.ctrl.Call(m, "restorePerson", ctx, id, version)
+	ret0, _ := ret[0].(Person)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// restorePerson indicates an expected call of restorePerson
+func (mr *MockStoreMockRecorder) restorePerson(ctx, id, version interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "restorePerson", reflect.TypeOf((*MockStore)(nil).restorePerson), ctx, id, version)
+}
+
+// purgeDeleted mocks base method
+func (m *MockStore) purgeDeleted(ctx context.Context, before time.Time) (int, error) {
+	ret := m.ctrl.Call(m, "purgeDeleted", ctx, before)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// purgeDeleted indicates an expected call of purgeDeleted
+func (mr *MockStoreMockRecorder) purgeDeleted(ctx, before interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "purgeDeleted", reflect.TypeOf((*MockStore)(nil).purgeDeleted), ctx, before)
+}
+
+// searchPeople mocks base method
+func (m *MockStore) searchPeople(ctx context.Context, q string, limit int) ([]SearchResult, error) {
+	ret := m.ctrl.Call(m, "searchPeople", ctx, q, limit)
+	ret0, _ := ret[0].([]SearchResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// searchPeople indicates an expected call of searchPeople
+func (mr *MockStoreMockRecorder) searchPeople(ctx, q, limit interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "searchPeople", reflect.TypeOf((*MockStore)(nil).searchPeople), ctx, q, limit)
+}
+
+// getAudit mocks base method
+func (m *MockStore) getAudit(ctx context.Context, query AuditQuery) ([]AuditEntry, error) {
+	ret := m.ctrl.Call(m, "getAudit", ctx, query)
+	ret0, _ := ret[0].([]AuditEntry)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// getAudit indicates an expected call of getAudit
+func (mr *MockStoreMockRecorder) getAudit(ctx, query interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "getAudit", reflect.TypeOf((*MockStore)(nil).getAudit), ctx, query)
+}
+
+// ping mocks base method
+func (m *MockStore) ping(ctx context.Context) error {
+	ret := m.ctrl.Call(m, "ping", ctx)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ping indicates an expected call of ping
+func (mr *MockStoreMockRecorder) ping(ctx interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ping", reflect.TypeOf((*MockStore)(nil).ping), ctx)
 }
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// testTimestamps checks that s keeps createdAt and updatedAt, ignoring the
+// values given by callers, and selects people by the time of their last
+// update
+func testTimestamps(t *testing.T, s Store) {
+	ctx := context.Background()
+	start := time.Now().Add(-time.Second)
+
+	peter, err := s.createPerson(ctx, Person{Name:"Peter", PhoneNr:"24525345626", CreatedAt:start.Add(-time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peter.CreatedAt.Before(start) || peter.CreatedAt.After(time.Now()) || !peter.UpdatedAt.Equal(peter.CreatedAt) {
+		t.Errorf("createPerson set wrong timestamps: %+v", peter)
+	}
+	paul, err := s.createPerson(ctx, Person{Name:"Paul", PhoneNr:"643265776357948984"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(time.Millisecond)
+	changed := peter
+	changed.PhoneNr = "0791234567"
+	changed.CreatedAt = time.Time{}
+	updated, err := s.updatePerson(ctx, changed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !updated.CreatedAt.Equal(peter.CreatedAt) || !updated.UpdatedAt.After(peter.UpdatedAt) {
+		t.Errorf("updatePerson set wrong timestamps: got %+v after %+v", updated, peter)
+	}
+	loaded, err := s.getPerson(ctx, peter.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded != updated {
+		t.Errorf("getPerson returned wrong person: got %+v want %+v", loaded, updated)
+	}
+
+	people, total, err := s.getPeople(ctx, PeopleQuery{UpdatedSince:updated.UpdatedAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 1 || len(people) != 1 || people[0].Id != peter.Id {
+		t.Errorf("getPeople returned wrong people updated since %v: %+v", updated.UpdatedAt, people)
+	}
+
+	// A delete is an update, so syncing clients learn about it
+	time.Sleep(time.Millisecond)
+	if err := s.deletePerson(ctx, paul.Id, 0); err != nil {
+		t.Fatal(err)
+	}
+	people, _, err = s.getPeople(ctx, PeopleQuery{UpdatedSince:updated.UpdatedAt.Add(time.Microsecond), IncludeDeleted:true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(people) != 1 || people[0].Id != paul.Id || people[0].DeletedAt == nil || !people[0].UpdatedAt.Equal(*people[0].DeletedAt) || !people[0].CreatedAt.Equal(paul.CreatedAt) {
+		t.Errorf("getPeople returned wrong deleted people: %+v", people)
+	}
+
+	restored, err := s.restorePerson(ctx, paul.Id, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !restored.UpdatedAt.After(people[0].UpdatedAt) || !restored.CreatedAt.Equal(paul.CreatedAt) {
+		t.Errorf("restorePerson set wrong timestamps: %+v", restored)
+	}
+
+	// Every audit entry has the time the change gave the person
+	for _, expected := range []struct {
+		id    int
+		times []time.Time
+	}{
+		{peter.Id, []time.Time{peter.CreatedAt, updated.UpdatedAt}},
+		{paul.Id, []time.Time{paul.CreatedAt, *people[0].DeletedAt, restored.UpdatedAt}},
+	} {
+		history, err := s.getAudit(ctx, AuditQuery{PersonId:expected.id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(history) != len(expected.times) {
+			t.Fatalf("getAudit returned wrong number of entries: got %+v", history)
+		}
+		for i, entry := range history {
+			if !entry.Time.Equal(expected.times[i]) {
+				t.Errorf("%v entry of person %d has wrong time: got %v want %v", entry.Op, expected.id, entry.Time, expected.times[i])
+			}
+		}
+	}
+}
+
+func TestMemoryStoreKeepsTimestamps(t *testing.T) {
+	testTimestamps(t, NewMemoryStore())
+}
+
+func TestDbStoreKeepsTimestamps(t *testing.T) {
+	testTimestamps(t, newSqliteTestStore(t))
+}
+
+func TestFileStoreKeepsTimestamps(t *testing.T) {
+	dir := t.TempDir()
+	fileStore, err := OpenFileStore(FileStoreOptions{Dir:dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testTimestamps(t, fileStore)
+	expected, _, err := fileStore.getPeople(context.Background(), PeopleQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reopenFileStore(t, fileStore, dir, func(fileStore *FileStore) {
+		recovered, _, err := fileStore.getPeople(context.Background(), PeopleQuery{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(recovered) != len(expected) || recovered[0] != expected[0] || recovered[1] != expected[1] {
+			t.Errorf("getPeople returned wrong people after reopening: got %+v want %+v", recovered, expected)
+		}
+	})
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// tenantHeader selects the tenant of callers that do not belong to one and
+// may act for any tenant
+const tenantHeader = "X-Tenant-ID"
+
+// defaultTenant holds the people of callers without tenant, and all people
+// stored before tenants were introduced
+const defaultTenant = "default"
+
+type tenantKey struct{}
+
+// withTenant returns a copy of ctx whose store calls act for tenant
+func withTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// tenantFrom returns the tenant the request ctx belongs to acts for. Every
+// Store method only sees and changes the people of this tenant.
+func tenantFrom(ctx context.Context) string {
+	if tenant, ok := ctx.Value(tenantKey{}).(string); ok {
+		return tenant
+	}
+	return defaultTenant
+}
+
+// validTenant accepts up to 64 letters, digits, '.', '_' and '-'
+func validTenant(tenant string) bool {
+	if len(tenant) == 0 || len(tenant) > 64 {
+		return false
+	}
+	for _, c := range tenant {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// selectTenant decides which tenant a request acts for. A caller that
+// belongs to a tenant always acts for it, other callers need the tenants:any
+// scope to choose one with the X-Tenant-ID header and use the default tenant
+// otherwise. With authentication disabled the header is always honoured.
+func selectTenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant := r.Header.Get(tenantHeader)
+		if tenant != "" && !validTenant(tenant) {
+			writeError(w, r, http.StatusBadRequest, tenantHeader+" must be 1 to 64 letters, digits, '.', '_' or '-'")
+			return
+		}
+
+		if principal, ok := principalFrom(r.Context()); ok && principal.Tenant != "" {
+			if tenant != "" && tenant != principal.Tenant {
+				writeError(w, r, http.StatusForbidden, "Caller belongs to tenant "+principal.Tenant+" and cannot act for tenant "+tenant)
+				return
+			}
+			tenant = principal.Tenant
+		} else if ok && tenant != "" && tenant != defaultTenant && !accessPolicy.grants(principal, scopeTenantsAny) {
+			writeError(w, r, http.StatusForbidden, "Acting for tenant "+tenant+" needs scope "+scopeTenantsAny)
+			return
+		}
+		if tenant == "" {
+			tenant = defaultTenant
+		}
+		next.ServeHTTP(w, r.WithContext(withTenant(r.Context(), tenant)))
+	})
+}
+
+// tenantQuotas limits the number of people a tenant may store. Tenants holds
+// the limits that differ from Default, a limit of 0 is no limit.
+type tenantQuotas struct {
+	Default int
+	Tenants map[string]int
+}
+
+// quotas are checked by the stores when a person is created, main reads
+// them from TENANT_QUOTA and TENANT_QUOTAS
+var quotas = tenantQuotas{}
+
+// limit returns the maximum number of people of tenant, 0 if unlimited
+func (q tenantQuotas) limit(tenant string) int {
+	if limit, ok := q.Tenants[tenant]; ok {
+		return limit
+	}
+	return q.Default
+}
+
+// exceeded returns ErrQuotaExceeded if tenant, which has count people, may
+// not store another one
+func (q tenantQuotas) exceeded(tenant string, count int) error {
+	if limit := q.limit(tenant); limit > 0 && count >= limit {
+		return fmt.Errorf("%w: tenant %s may store %d people", ErrQuotaExceeded, tenant, limit)
+	}
+	return nil
+}
+
+// parseTenantQuotas reads a default limit and a list of tenant limits like
+// "team-a=1000,team-b=50000"
+func parseTenantQuotas(defaultValue string, tenants string) (tenantQuotas, error) {
+	defaultLimit, err := strconv.Atoi(defaultValue)
+	if err != nil || defaultLimit < 0 {
+		return tenantQuotas{}, fmt.Errorf("tenant quota %q must be a number of people, 0 for no limit", defaultValue)
+	}
+
+	parsed := tenantQuotas{Default: defaultLimit, Tenants: map[string]int{}}
+	for _, entry := range strings.Split(tenants, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		tenant, value, ok := strings.Cut(entry, "=")
+		if !ok || !validTenant(tenant) {
+			return tenantQuotas{}, fmt.Errorf("tenant quota %q must look like tenant=limit", entry)
+		}
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			return tenantQuotas{}, fmt.Errorf("invalid quota of tenant %s: %q", tenant, value)
+		}
+		parsed.Tenants[tenant] = limit
+	}
+	return parsed, nil
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+)
+
+// useQuotas applies q until the test finishes
+func useQuotas(t *testing.T, q tenantQuotas) {
+	t.Cleanup(func() { quotas = tenantQuotas{} })
+	quotas = q
+}
+
+// testTenantIsolation checks that no Store method of s reaches the people
+// of another tenant, and that the quota of a tenant is enforced
+func testTenantIsolation(t *testing.T, s Store) {
+	useQuotas(t, tenantQuotas{Tenants: map[string]int{"team-b": 1}})
+	teamA := withTenant(context.Background(), "team-a")
+	teamB := withTenant(context.Background(), "team-b")
+
+	peter, err := s.createPerson(teamA, Person{Name:"Peter", PhoneNr:"24525345626"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peter.Tenant != "team-a" {
+		t.Errorf("createPerson stored wrong tenant: got %v want %v", peter.Tenant, "team-a")
+	}
+	// A tenant given with the person is ignored
+	paul, err := s.createPerson(teamB, Person{Name:"Paul", PhoneNr:"643265776357948984", Tenant:"team-a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paul.Tenant != "team-b" {
+		t.Errorf("createPerson stored wrong tenant: got %v want %v", paul.Tenant, "team-b")
+	}
+
+	if _, err := s.getPerson(teamB, peter.Id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("getPerson of other tenant returned wrong error: got %v want %v", err, ErrNotFound)
+	}
+	if _, err := s.updatePerson(teamB, Person{Id:peter.Id, Name:"Mallory", PhoneNr:"1"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("updatePerson of other tenant returned wrong error: got %v want %v", err, ErrNotFound)
+	}
+	if err := s.deletePerson(teamB, peter.Id, 0); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deletePerson of other tenant returned wrong error: got %v want %v", err, ErrNotFound)
+	}
+
+	people, total, err := s.getPeople(teamB, PeopleQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 1 || len(people) != 1 || people[0].Id != paul.Id {
+		t.Errorf("getPeople returned people of other tenant: %+v", people)
+	}
+	results, err := s.searchPeople(teamB, "Peter", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 0 {
+		t.Errorf("searchPeople returned people of other tenant: %+v", results)
+	}
+
+	unchanged, err := s.getPerson(teamA, peter.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unchanged != peter {
+		t.Errorf("person was changed by other tenant: got %+v want %+v", unchanged, peter)
+	}
+
+	if _, err := s.createPerson(teamB, Person{Name:"Alice", PhoneNr:"12343463462345243"}); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("createPerson beyond quota returned wrong error: got %v want %v", err, ErrQuotaExceeded)
+	}
+	if _, err := s.createPerson(teamA, Person{Name:"Alice", PhoneNr:"12343463462345243"}); err != nil {
+		t.Errorf("quota of other tenant was applied: %v", err)
+	}
+	if err := s.deletePerson(teamB, paul.Id, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.createPerson(teamB, Person{Name:"Alice", PhoneNr:"12343463462345243"}); err != nil {
+		t.Errorf("createPerson after delete returned error: %v", err)
+	}
+}
+
+func TestMemoryStoreIsolatesTenants(t *testing.T) {
+	testTenantIsolation(t, NewMemoryStore())
+}
+
+func TestDbStoreIsolatesTenants(t *testing.T) {
+	testTenantIsolation(t, newSqliteTestStore(t))
+}
+
+func TestFileStoreIsolatesTenants(t *testing.T) {
+	dir := t.TempDir()
+	fileStore, err := OpenFileStore(FileStoreOptions{Dir:dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testTenantIsolation(t, fileStore)
+
+	reopenFileStore(t, fileStore, dir, func(fileStore *FileStore) {
+		_, total, err := fileStore.getPeople(withTenant(context.Background(), "team-a"), PeopleQuery{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total != 2 {
+			t.Errorf("getPeople returned wrong number of people after reopening: got %v want %v", total, 2)
+		}
+	})
+}
+
+func TestParseTenantQuotas(t *testing.T) {
+	parsed, err := parseTenantQuotas("100", "team-a=5, team-b=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.limit("team-a") != 5 || parsed.limit("team-b") != 0 || parsed.limit("team-c") != 100 {
+		t.Errorf("parseTenantQuotas returned wrong limits: got %+v", parsed)
+	}
+
+	for _, tenants := range []string{"team-a", "team a=5", "team-a=-1", "team-a=many"} {
+		if _, err := parseTenantQuotas("0", tenants); err == nil {
+			t.Errorf("parseTenantQuotas accepted %q", tenants)
+		}
+	}
+	if _, err := parseTenantQuotas("unlimited", ""); err == nil {
+		t.Error("parseTenantQuotas accepted an invalid default")
+	}
+}
+
+func TestSelectTenant(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		principal      *Principal
+		header         string
+		expectedStatus int
+		expectedTenant string
+	}{
+		{"no principal and header", nil, "", http.StatusOK, defaultTenant},
+		{"header without principal", nil, "team-a", http.StatusOK, "team-a"},
+		{"principal without tenant", &Principal{Subject:"admin", Roles:[]string{"admin"}}, "team-b", http.StatusOK, "team-b"},
+		{"principal with tenants:any", &Principal{Subject:"sync", Roles:[]string{"reader"}, Scopes:[]string{scopeTenantsAny}}, "team-b", http.StatusOK, "team-b"},
+		{"tenantless reader with header", &Principal{Subject:"bob", Roles:[]string{"reader"}}, "team-b", http.StatusForbidden, ""},
+		{"tenantless reader without header", &Principal{Subject:"bob", Roles:[]string{"reader"}}, "", http.StatusOK, defaultTenant},
+		{"tenantless reader with default header", &Principal{Subject:"bob", Roles:[]string{"reader"}}, defaultTenant, http.StatusOK, defaultTenant},
+		{"principal with tenant", &Principal{Subject:"alice", Roles:[]string{"reader"}, Tenant:"team-a"}, "", http.StatusOK, "team-a"},
+		{"matching header", &Principal{Subject:"alice", Roles:[]string{"reader"}, Tenant:"team-a"}, "team-a", http.StatusOK, "team-a"},
+		{"other tenant", &Principal{Subject:"alice", Roles:[]string{"reader"}, Tenant:"team-a"}, "team-b", http.StatusForbidden, ""},
+		{"invalid header", nil, "team a", http.StatusBadRequest, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+
+			mockStore := NewMockStore(mockCtrl)
+			store = mockStore
+
+			tenant := ""
+			if tc.expectedStatus == http.StatusOK {
+				mockStore.EXPECT().getPerson(gomock.Any(), 3).DoAndReturn(func(ctx context.Context, id int) (Person, error) {
+					tenant = tenantFrom(ctx)
+					return Person{Id:3, Name:"Peter", PhoneNr:"24525345626"}, nil
+				}).Times(1)
+			}
+			if tc.principal != nil {
+				useAuthenticator(t, staticAuthenticator(*tc.principal))
+			}
+
+			req, err := http.NewRequest("GET", "/people/3", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.header != "" {
+				req.Header.Set(tenantHeader, tc.header)
+			}
+
+			rr := httptest.NewRecorder()
+			router := createRouter()
+
+			router.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tc.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v",
+					status, tc.expectedStatus)
+			}
+			if tenant != tc.expectedTenant {
+				t.Errorf("store got wrong tenant: got %v want %v", tenant, tc.expectedTenant)
+			}
+		})
+	}
+}
+
+func TestCreatePersonReturnsQuotaExceeded(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	store = NewMockStore(mockCtrl)
+	mockStore := store.(*MockStore)
+
+	mockStore.EXPECT().createPerson(gomock.Any(), Person{Name:"Peter", PhoneNr:"24525345626"}).Return(Person{}, ErrQuotaExceeded).Times(1)
+
+	req, err := http.NewRequest("POST", "/people", strings.NewReader("name=Peter&phoneNr=24525345626"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set(tenantHeader, "team-a")
+
+	rr := httptest.NewRecorder()
+	router := createRouter()
+
+	router.ServeHTTP(rr, req)
+
+	expectedStatus := http.StatusForbidden
+	if status := rr.Code; status != expectedStatus {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expectedStatus)
+	}
+
+	expectedBody := `{"type":"/problems/quota-exceeded","title":"Quota exceeded","status":403,"detail":"Tenant team-a has reached its quota of people, delete some before creating more","instance":"/people","requestId":"test-request-id"}`
+	if rr.Body.String() != expectedBody {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expectedBody)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// routeTimeouts limits how long the store may take for a request. Routes
+// holds the limits that differ from Default by route name.
+type routeTimeouts struct {
+	Default time.Duration
+	Routes  map[string]time.Duration
+}
+
+// requestTimeouts are applied by limitRequestTime, main reads them from
+// REQUEST_TIMEOUT and ROUTE_TIMEOUTS
+var requestTimeouts = routeTimeouts{Default: 30 * time.Second}
+
+// parseRouteTimeouts reads a default duration and a list of route limits
+// like "searchPeople=2s,getPeople=5s". Every name must be a route of router.
+func parseRouteTimeouts(router *mux.Router, defaultValue string, routes string) (routeTimeouts, error) {
+	defaultTimeout, err := time.ParseDuration(defaultValue)
+	if err != nil {
+		return routeTimeouts{}, fmt.Errorf("invalid request timeout: %v", err)
+	}
+
+	timeouts := routeTimeouts{Default: defaultTimeout, Routes: map[string]time.Duration{}}
+	for _, entry := range strings.Split(routes, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return routeTimeouts{}, fmt.Errorf("route timeout %q must look like name=duration", entry)
+		}
+		if router.Get(name) == nil {
+			return routeTimeouts{}, fmt.Errorf("route timeout for unknown route %q", name)
+		}
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			return routeTimeouts{}, fmt.Errorf("invalid timeout of route %s: %v", name, err)
+		}
+		timeouts.Routes[name] = timeout
+	}
+	return timeouts, nil
+}
+
+// limitRequestTime gives the context of a request the deadline of its
+// route, a timeout of 0 leaves the request unlimited
+func limitRequestTime(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeout := requestTimeouts.Default
+		if route := mux.CurrentRoute(r); route != nil {
+			if routeTimeout, ok := requestTimeouts.Routes[route.GetName()]; ok {
+				timeout = routeTimeout
+			}
+		}
+		if timeout <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
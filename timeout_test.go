@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRouteTimeouts(t *testing.T) {
+	router := createRouter()
+
+	timeouts, err := parseRouteTimeouts(router, "10s", "searchPeople=2s, getPeople=500ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if timeouts.Default != 10*time.Second {
+		t.Errorf("parseRouteTimeouts returned wrong default: got %v want %v", timeouts.Default, 10*time.Second)
+	}
+	if timeouts.Routes["searchPeople"] != 2*time.Second || timeouts.Routes["getPeople"] != 500*time.Millisecond {
+		t.Errorf("parseRouteTimeouts returned wrong route timeouts: got %v", timeouts.Routes)
+	}
+
+	for _, routes := range []string{"searchPeople", "listPeople=2s", "getPeople=soon"} {
+		if _, err := parseRouteTimeouts(router, "10s", routes); err == nil {
+			t.Errorf("parseRouteTimeouts accepted %q", routes)
+		}
+	}
+	if _, err := parseRouteTimeouts(router, "never", ""); err == nil {
+		t.Error("parseRouteTimeouts accepted an invalid default")
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/scriptcoffee/go-rest-api"
+
+// tracer is looked up on every use, so a provider set later, as tests do,
+// is picked up
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// setupTracing exports spans over OTLP/HTTP when an endpoint is configured
+// with OTEL_EXPORTER_OTLP_ENDPOINT or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT.
+// The exporter reads the other OTEL_EXPORTER_OTLP_* variables itself, the
+// sampler is set with OTEL_TRACES_SAMPLER. W3C trace context is propagated
+// in any case. The returned function flushes the remaining spans.
+func setupTracing(ctx context.Context) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", "") == "" && getEnv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "") == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", getEnv("OTEL_SERVICE_NAME", "go-rest-api")),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// traceRequests starts a server span for every request, continuing the
+// trace of the caller given in the traceparent header
+func traceRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		route := r.URL.Path
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+
+		ctx, span := tracer().Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("http.route", route),
+				attribute.String("url.path", r.URL.Path),
+			))
+		defer span.End()
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		span.SetAttributes(attribute.Int("http.response.status_code", recorder.status))
+		if recorder.status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(recorder.status))
+		}
+	})
+}
+
+// tracingStore records a span for every operation of the store it wraps
+type tracingStore struct {
+	next Store
+}
+
+func newTracingStore(next Store) *tracingStore {
+	return &tracingStore{next}
+}
+
+func (store *tracingStore) start(ctx context.Context, operation string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer().Start(ctx, "store."+operation, trace.WithAttributes(attributes...))
+}
+
+// end finishes span. Errors the client caused, like a missing person, are
+// noted but do not mark the span as failed.
+func (store *tracingStore) end(span trace.Span, err error) {
+	if err != nil {
+		kind := errorKind(err)
+		span.SetAttributes(attribute.String("store.error", kind))
+		if kind == "other" || kind == "unavailable" || kind == "timeout" || kind == "canceled" {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+	}
+	span.End()
+}
+
+func (store *tracingStore) getPeople(ctx context.Context, query PeopleQuery) ([]Person, int, error) {
+	ctx, span := store.start(ctx, "getPeople", attribute.Int("store.limit", query.Limit))
+	people, total, err := store.next.getPeople(ctx, query)
+	store.end(span, err)
+	return people, total, err
+}
+
+func (store *tracingStore) getPerson(ctx context.Context, id int) (Person, error) {
+	ctx, span := store.start(ctx, "getPerson", attribute.Int("person.id", id))
+	person, err := store.next.getPerson(ctx, id)
+	store.end(span, err)
+	return person, err
+}
+
+func (store *tracingStore) createPerson(ctx context.Context, p Person) (Person, error) {
+	ctx, span := store.start(ctx, "createPerson")
+	person, err := store.next.createPerson(ctx, p)
+	store.end(span, err)
+	return person, err
+}
+
+func (store *tracingStore) updatePerson(ctx context.Context, p Person) (Person, error) {
+	ctx, span := store.start(ctx, "updatePerson", attribute.Int("person.id", p.Id))
+	person, err := store.next.updatePerson(ctx, p)
+	store.end(span, err)
+	return person, err
+}
+
+func (store *tracingStore) deletePerson(ctx context.Context, id int, version int) error {
+	ctx, span := store.start(ctx, "deletePerson", attribute.Int("person.id", id))
+	err := store.next.deletePerson(ctx, id, version)
+	store.end(span, err)
+	return err
+}
+
+func (store *tracingStore) restorePerson(ctx context.Context, id int, version int) (Person, error) {
+	ctx, span := store.start(ctx, "restorePerson", attribute.Int("person.id", id))
+	person, err := store.next.restorePerson(ctx, id, version)
+	store.end(span, err)
+	return person, err
+}
+
+func (store *tracingStore) purgeDeleted(ctx context.Context, before time.Time) (int, error) {
+	ctx, span := store.start(ctx, "purgeDeleted")
+	purged, err := store.next.purgeDeleted(ctx, before)
+	span.SetAttributes(attribute.Int("store.purged", purged))
+	store.end(span, err)
+	return purged, err
+}
+
+func (store *tracingStore) searchPeople(ctx context.Context, q string, limit int) ([]SearchResult, error) {
+	ctx, span := store.start(ctx, "searchPeople", attribute.Int("store.limit", limit))
+	results, err := store.next.searchPeople(ctx, q, limit)
+	store.end(span, err)
+	return results, err
+}
+
+func (store *tracingStore) getAudit(ctx context.Context, query AuditQuery) ([]AuditEntry, error) {
+	ctx, span := store.start(ctx, "getAudit", attribute.Int("person.id", query.PersonId), attribute.Int("store.limit", query.Limit))
+	entries, err := store.next.getAudit(ctx, query)
+	store.end(span, err)
+	return entries, err
+}
+
+func (store *tracingStore) ping(ctx context.Context) error {
+	ctx, span := store.start(ctx, "ping")
+	err := store.next.ping(ctx)
+	store.end(span, err)
+	return err
+}
+
+// Close closes the wrapped store if it needs closing
+func (store *tracingStore) Close() error {
+	if closer, ok := store.next.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
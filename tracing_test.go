@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// recordSpans collects the spans ended until the test finishes in memory
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	previousProvider := otel.GetTracerProvider()
+	previousPropagator := otel.GetTextMapPropagator()
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return exporter
+}
+
+func spanAttribute(span tracetest.SpanStub, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTracingContinuesTraceOfCaller(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockStore := NewMockStore(mockCtrl)
+	store = newTracingStore(mockStore)
+
+	mockStore.EXPECT().getPerson(gomock.Any(), 3).Return(Person{Id:3, Name:"Peter", PhoneNr:"24525345626"}, nil).Times(1)
+
+	exporter := recordSpans(t)
+
+	req, err := http.NewRequest("GET", "/people/3", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+
+	router := createRouter()
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("wrong number of spans: got %v want %v", len(spans), 2)
+	}
+	storeSpan, serverSpan := spans[0], spans[1]
+
+	if serverSpan.Name != "GET /people/{id}" {
+		t.Errorf("server span has wrong name: got %v want %v", serverSpan.Name, "GET /people/{id}")
+	}
+	if traceId := serverSpan.SpanContext.TraceID().String(); traceId != "0af7651916cd43dd8448eb211c80319c" {
+		t.Errorf("server span has wrong trace id: got %v want %v", traceId, "0af7651916cd43dd8448eb211c80319c")
+	}
+	if parentId := serverSpan.Parent.SpanID().String(); parentId != "b7ad6b7169203331" || !serverSpan.Parent.IsRemote() {
+		t.Errorf("server span has wrong parent: got %v want remote %v", parentId, "b7ad6b7169203331")
+	}
+	if status := spanAttribute(serverSpan, "http.response.status_code").AsInt64(); status != 200 {
+		t.Errorf("server span has wrong status code: got %v want %v", status, 200)
+	}
+
+	if storeSpan.Name != "store.getPerson" {
+		t.Errorf("store span has wrong name: got %v want %v", storeSpan.Name, "store.getPerson")
+	}
+	if storeSpan.Parent.SpanID() != serverSpan.SpanContext.SpanID() {
+		t.Errorf("store span is not a child of the server span")
+	}
+	if id := spanAttribute(storeSpan, "person.id").AsInt64(); id != 3 {
+		t.Errorf("store span has wrong person id: got %v want %v", id, 3)
+	}
+}
+
+func TestTracingMarksFailedStoreCalls(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockStore := NewMockStore(mockCtrl)
+	store = newTracingStore(mockStore)
+
+	mockStore.EXPECT().getPerson(gomock.Any(), 3).Return(Person{}, errors.New("connection refused")).Times(1)
+	mockStore.EXPECT().getPerson(gomock.Any(), 4).Return(Person{}, ErrNotFound).Times(1)
+
+	exporter := recordSpans(t)
+
+	router := createRouter()
+	for _, path := range []string{"/people/3", "/people/4"} {
+		req, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 4 {
+		t.Fatalf("wrong number of spans: got %v want %v", len(spans), 4)
+	}
+	if spans[0].Status.Code != codes.Error || spans[1].Status.Code != codes.Error {
+		t.Errorf("failed request was not marked as error: got %v and %v", spans[0].Status, spans[1].Status)
+	}
+	if spans[2].Status.Code == codes.Error || spans[3].Status.Code == codes.Error {
+		t.Errorf("missing person was marked as error: got %v and %v", spans[2].Status, spans[3].Status)
+	}
+	if kind := spanAttribute(spans[2], "store.error").AsString(); kind != "not_found" {
+		t.Errorf("store span has wrong error kind: got %v want %v", kind, "not_found")
+	}
+}